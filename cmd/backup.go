@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/registry"
+
+	"github.com/spf13/pflag"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const (
+	defaultBackupParallelism = 4
+)
+
+// runBackup copies the given images to the backup registries and prints the backup images, their digest and the copy time.
+// Returns the exit code: non zero if any image failed to be copied.
+// The copies are not recorded as ImageBackups: the command doesn't need access to a cluster.
+func runBackup(args []string) int {
+	flags := pflag.NewFlagSet("backup", pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s backup [flags] [image...]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Copies the images to the backup registries.")
+		flags.PrintDefaults()
+	}
+	file := flags.StringP("file", "f", "", "Path of a file with the images to copy, one per line (- for stdin). Empty lines and lines starting with # are ignored.")
+	parallelism := flags.IntP("parallel", "p", defaultBackupParallelism, "Number of the images copied at the same time.")
+	flags.AddFlagSet(pflag.CommandLine)
+	flags.Parse(args)
+
+	logf.SetLogger(zap.Logger(false))
+	if err := config.GlobalConfig.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+	if *parallelism < 1 {
+		fmt.Fprintln(os.Stderr, "Number of the parallel copies should be positive")
+		return 1
+	}
+
+	images := flags.Args()
+	if *file != "" {
+		listed, err := readImageList(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read the images: %v\n", err)
+			return 1
+		}
+		images = append(images, listed...)
+	}
+	images = unique(images)
+	if len(images) == 0 {
+		flags.Usage()
+		return 1
+	}
+
+	regClient := registry.NewClientFromConfig()
+	return backupImages(os.Stdout, os.Stderr, images, *parallelism, regClient.Belongs, regClient.Backup)
+}
+
+// backupImages copies the images with the given number of parallel copies, prints the result of each copy to out
+// and the summary to errOut. The images which are already backup images are not copied.
+// Returns the exit code: non zero if any image failed to be copied, even if to some of the backup registries only.
+func backupImages(out, errOut io.Writer, images []string, parallelism int,
+	belongs func(string) bool, backup func(string) (*registry.ImageInfo, error)) int {
+	start := time.Now()
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	copied, failed := 0, 0
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for img := range queue {
+				if belongs(img) {
+					lock.Lock()
+					fmt.Fprintf(out, "%s\tALREADY BACKED UP\n", img)
+					lock.Unlock()
+					continue
+				}
+				start := time.Now()
+				info, err := backup(img)
+				elapsed := time.Since(start).Round(time.Millisecond)
+
+				lock.Lock()
+				switch {
+				case info == nil:
+					failed++
+					fmt.Fprintf(out, "%s\tFAILED\t%s\t%v\n", img, elapsed, err)
+				case err != nil:
+					// copied to some of the backup registries only
+					copied++
+					failed++
+					fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%v\n", img, strings.Join(info.Replicas, ","), info.Digest, elapsed, err)
+				default:
+					copied++
+					fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", img, strings.Join(info.Replicas, ","), info.Digest, elapsed)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, img := range images {
+		queue <- img
+	}
+	close(queue)
+	wg.Wait()
+
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if failed > 0 {
+		fmt.Fprintf(errOut, "%d of %d image(s) failed to be copied in %s\n", failed, len(images), elapsed)
+		return 1
+	}
+	fmt.Fprintf(errOut, "%d image(s) backed up, %d already backed up in %s\n", copied, len(images)-copied, elapsed)
+	return 0
+}
+
+// readImageList reads the images listed in the given file, one per line, from stdin if -
+func readImageList(file string) ([]string, error) {
+	f := os.Stdin
+	if file != "-" {
+		var err error
+		if f, err = os.Open(file); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+
+	images := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		images = append(images, line)
+	}
+	return images, scanner.Err()
+}
+
+// unique returns the given images without the duplicates, in the same order
+func unique(images []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, img := range images {
+		if !seen[img] {
+			seen[img] = true
+			out = append(out, img)
+		}
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"image-clone-controller/pkg/registry"
+)
+
+func TestReadImageList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatalf("Failed to create the directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "images.txt")
+	content := "# base images\nnginx:1.19\n\n  redis:6  \n#alpine:3.12\nbusybox\n"
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write the file: %v", err)
+	}
+
+	images, err := readImageList(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"nginx:1.19", "redis:6", "busybox"}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, images)
+	}
+
+	if _, err := readImageList(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestUnique(t *testing.T) {
+	images := unique([]string{"nginx:1.19", "redis:6", "nginx:1.19", "busybox", "redis:6"})
+	expected := []string{"nginx:1.19", "redis:6", "busybox"}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, images)
+	}
+}
+
+func TestBackupImages(t *testing.T) {
+	testCases := []struct {
+		name             string
+		images           []string
+		expectedCode     int
+		expectedOutput   []string
+		expectedFinished string
+	}{
+		{
+			name:             "Copied",
+			images:           []string{"nginx:1.19", "quay.io/org/redis:6"},
+			expectedOutput:   []string{"nginx:1.19\tquay.io/org/nginx:1.19\tsha256:a", "quay.io/org/redis:6\tALREADY BACKED UP"},
+			expectedFinished: "1 image(s) backed up, 1 already backed up",
+		},
+		{
+			name:             "Failed",
+			images:           []string{"nginx:1.19", "broken:1"},
+			expectedCode:     1,
+			expectedOutput:   []string{"nginx:1.19\tquay.io/org/nginx:1.19\tsha256:a", "broken:1\tFAILED"},
+			expectedFinished: "1 of 2 image(s) failed to be copied",
+		},
+		{
+			name:             "Copied to some of the backup registries",
+			images:           []string{"partial:1"},
+			expectedCode:     1,
+			expectedOutput:   []string{"partial:1\tquay.io/org/partial:1\tsha256:a"},
+			expectedFinished: "1 of 1 image(s) failed to be copied",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			regClient := registry.NewClient("quay.io", "org", "", "", 0)
+			backup := func(img string) (*registry.ImageInfo, error) {
+				if strings.HasPrefix(img, "broken") {
+					return nil, errors.New("manifest unknown")
+				}
+				dest := regClient.Destination(img)
+				info := &registry.ImageInfo{Name: dest, Digest: "sha256:a", Replicas: []string{dest}}
+				if strings.HasPrefix(img, "partial") {
+					return info, errors.New("mirror.io: unauthorized")
+				}
+				return info, nil
+			}
+
+			out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+			code := backupImages(out, errOut, tc.images, 2, regClient.Belongs, backup)
+			if code != tc.expectedCode {
+				t.Errorf("Expected exit code %d, got %d", tc.expectedCode, code)
+			}
+			for _, line := range tc.expectedOutput {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("Expected %q in the output:\n%s", line, out.String())
+				}
+			}
+			if !strings.HasPrefix(errOut.String(), tc.expectedFinished) {
+				t.Errorf("Expected the summary %q, got %q", tc.expectedFinished, errOut.String())
+			}
+		})
+	}
+}
@@ -3,9 +3,15 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
+	"image-clone-controller/pkg/apis"
 	"image-clone-controller/pkg/config"
 	"image-clone-controller/pkg/controller"
+	"image-clone-controller/pkg/controller/imageclonepolicy"
+	"image-clone-controller/pkg/gc"
+	"image-clone-controller/pkg/health"
+	"image-clone-controller/pkg/registry"
 
 	"github.com/spf13/pflag"
 	ctrconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
@@ -17,15 +23,29 @@ import (
 
 const (
 	leaderLockConfigMap = "image-clone-controller"
+	// how long the backup registry check result is reused by the readiness probe
+	readinessCheckTTL = 30 * time.Second
 )
 
 var (
 	log = logf.Log.WithName("main")
+
+	// subcommands run instead of the controller, they return the exit code
+	commands = map[string]func(args []string) int{
+		"backup":    runBackup,
+		"manifests": runManifests,
+		"scan":      runScan,
+	}
 )
 
 func main() {
 	// add flags registered by all imported packages
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+	if len(os.Args) > 1 {
+		if command, exists := commands[os.Args[1]]; exists {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
 	pflag.Parse()
 
 	err := config.GlobalConfig.Validate()
@@ -43,17 +63,54 @@ func main() {
 	}
 
 	mgr, err := manager.New(cfg, manager.Options{
-		// leader election: not strictly necessary for this test task
-		// idea was to prevent the concurrent event handling during RollingUpgrade
-		LeaderElection:     false,
-		LeaderElectionID:   leaderLockConfigMap,
-		MetricsBindAddress: "0",
+		// leader election prevents the concurrent copies and updates
+		// from several replicas and during RollingUpgrade
+		LeaderElection:          config.GlobalConfig.LeaderElection,
+		LeaderElectionID:        leaderLockConfigMap,
+		LeaderElectionNamespace: config.GlobalConfig.LeaderElectionNamespace,
+		LeaseDuration:           &config.GlobalConfig.LeaderElectionLeaseDuration,
+		RenewDeadline:           &config.GlobalConfig.LeaderElectionRenewDeadline,
+		MetricsBindAddress:      config.GlobalConfig.MetricsBindAddress,
 	})
 	if err != nil {
 		log.Error(err, "Failed to create the manager")
 		os.Exit(1)
 	}
 
+	if config.GlobalConfig.CredentialsWatched() {
+		if err := mgr.Add(config.NewCredentialsWatcherFromConfig()); err != nil {
+			log.Error(err, "Failed to add the credentials watcher")
+			os.Exit(1)
+		}
+	}
+
+	if config.GlobalConfig.HealthProbeBindAddress != "0" {
+		readiness := health.CachedChecker(registry.NewClientFromConfig().Ping, readinessCheckTTL)
+		if err := mgr.Add(health.NewServer(config.GlobalConfig.HealthProbeBindAddress, readiness)); err != nil {
+			log.Error(err, "Failed to add the health probes")
+			os.Exit(1)
+		}
+	}
+
+	if config.GlobalConfig.GCInterval > 0 {
+		if err := mgr.Add(gc.NewCollectorFromConfig(mgr.GetClient(), mgr.GetAPIReader())); err != nil {
+			log.Error(err, "Failed to add the garbage collector")
+			os.Exit(1)
+		}
+	}
+
+	log.Info("Registering API types")
+	if err := apis.AddToScheme(mgr.GetScheme()); err != nil {
+		log.Error(err, "Failed to register the API types")
+		os.Exit(1)
+	}
+
+	// the cache isn't started yet: the policy is read from the API
+	if err := imageclonepolicy.ApplyClusterPolicy(mgr.GetAPIReader()); err != nil {
+		log.Error(err, "Failed to apply the image clone policy")
+		os.Exit(1)
+	}
+
 	log.Info("Registering controllers")
 	if err := controller.AddToManager(mgr); err != nil {
 		log.Error(err, "Failed to register all the controllers")
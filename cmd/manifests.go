@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/manifests"
+
+	"github.com/spf13/pflag"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// runManifests backs up the images of the workloads of the manifests read from the given files (stdin if none)
+// and writes the manifests rewritten to the backup images to stdout.
+// Returns the exit code: non zero if any image failed to be copied.
+func runManifests(args []string) int {
+	flags := pflag.NewFlagSet("manifests", pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s manifests [flags] [file...]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Backs up the images of the manifests read from the files (- or none for stdin) and writes the rewritten manifests to stdout.")
+		flags.PrintDefaults()
+	}
+	dryRun := flags.Bool("dry-run", false, "Don't copy the images, only rewrite the manifests to the planned backup images.")
+	reportFile := flags.String("report", "", "Path of the JSON report of the processed images, - for stderr.")
+	flags.AddFlagSet(pflag.CommandLine)
+	flags.Parse(args)
+
+	logf.SetLogger(zap.Logger(false))
+	if err := config.GlobalConfig.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	readers := []io.Reader{}
+	for _, f := range files {
+		if f == "-" {
+			readers = append(readers, os.Stdin)
+			continue
+		}
+		file, err := os.Open(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open the manifests: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		// the documents of the different files are separated
+		readers = append(readers, file, strings.NewReader("\n---\n"))
+	}
+
+	report, err := manifests.NewProcessorFromConfig(*dryRun).Process(io.MultiReader(readers...), os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to process the manifests: %v\n", err)
+		return 1
+	}
+
+	if *reportFile != "" {
+		if err := writeReport(*reportFile, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write the report: %v\n", err)
+			return 1
+		}
+	}
+	if report.Failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d image(s) failed to be copied\n", report.Failed)
+		return 1
+	}
+	return 0
+}
+
+// writeReport writes the JSON report to the given file, to stderr if -
+func writeReport(file string, report *manifests.Report) error {
+	if file == "-" {
+		return report.WriteJSON(os.Stderr)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
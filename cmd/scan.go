@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"image-clone-controller/pkg/apis"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/registry"
+	"image-clone-controller/pkg/scan"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+// runScan lists the images of the workloads of the cluster with their backup state and the backup coverage.
+// Returns the exit code.
+func runScan(args []string) int {
+	flags := pflag.NewFlagSet("scan", pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s scan [flags]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Lists the images of the workloads of the cluster with their backup state.")
+		flags.PrintDefaults()
+	}
+	output := flags.StringP("output", "o", outputTable, "Output format: table, json or csv.")
+	flags.AddFlagSet(pflag.CommandLine)
+	flags.Parse(args)
+
+	logf.SetLogger(zap.Logger(false))
+	if err := config.GlobalConfig.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+	if *output != outputTable && *output != outputJSON && *output != outputCSV {
+		fmt.Fprintf(os.Stderr, "Invalid output format %q, expected %s, %s or %s\n", *output, outputTable, outputJSON, outputCSV)
+		return 1
+	}
+
+	cfg, err := ctrconfig.GetConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get KubeConfig: %v\n", err)
+		return 1
+	}
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to register the API types: %v\n", err)
+		return 1
+	}
+	if err := apis.AddToScheme(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to register the API types: %v\n", err)
+		return 1
+	}
+	c, err := client.New(cfg, client.Options{Scheme: s})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create the client: %v\n", err)
+		return 1
+	}
+
+	report, err := scan.Scan(c, registry.NewClientFromConfig(), config.Current())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to scan the cluster: %v\n", err)
+		return 1
+	}
+	switch *output {
+	case outputJSON:
+		err = report.WriteJSON(os.Stdout)
+	case outputCSV:
+		// the coverage is written apart for the CSV output to only have image records
+		if err = report.WriteCSV(os.Stdout); err == nil {
+			err = report.WriteCoverage(os.Stderr)
+		}
+	default:
+		err = report.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the report: %v\n", err)
+		return 1
+	}
+	return 0
+}
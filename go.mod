@@ -3,8 +3,13 @@ module image-clone-controller
 go 1.13
 
 require (
+	github.com/go-logr/logr v0.1.0
+	github.com/prometheus/client_golang v1.0.0
 	github.com/spf13/pflag v1.0.2
+	golang.org/x/time v0.0.0-20180412165947-fbb02b2291d2
 	k8s.io/api v0.15.9
 	k8s.io/apimachinery v0.15.9
+	k8s.io/client-go v0.0.0-20190918200256-06eb1244587a
 	sigs.k8s.io/controller-runtime v0.3.0
+	sigs.k8s.io/yaml v1.1.0
 )
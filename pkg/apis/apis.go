@@ -0,0 +1,19 @@
+package apis
+
+import (
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func init() {
+	AddToSchemes = append(AddToSchemes, v1alpha1.SchemeBuilder.AddToScheme)
+}
+
+// AddToSchemes is a list of functions to add all the API types to a scheme
+var AddToSchemes runtime.SchemeBuilder
+
+// AddToScheme adds all the API types to the given scheme
+func AddToScheme(s *runtime.Scheme) error {
+	return AddToSchemes.AddToScheme(s)
+}
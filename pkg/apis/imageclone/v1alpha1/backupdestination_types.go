@@ -0,0 +1,49 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultBackupDestinationName is the name of the BackupDestination used for the workloads of its namespace
+	DefaultBackupDestinationName = "default"
+	// BackupDestinationUsernameKey is the key of the username in the credentials Secret
+	BackupDestinationUsernameKey = "username"
+	// BackupDestinationPasswordKey is the key of the password in the credentials Secret
+	BackupDestinationPasswordKey = "password"
+)
+
+// BackupDestinationSpec defines where the images of the namespace are backed up
+type BackupDestinationSpec struct {
+	// Registry is the backup image registry
+	Registry string `json:"registry"`
+	// Organization is the backup image registry's organization
+	Organization string `json:"organization"`
+	// CredentialsSecret is the name of the Secret from the same namespace holding the username and the password
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BackupDestination overrides the backup registry for the workloads of its namespace
+// +kubebuilder:resource:path=backupdestinations,scope=Namespaced
+type BackupDestination struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BackupDestinationSpec `json:"spec,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BackupDestinationList contains a list of BackupDestination
+type BackupDestinationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BackupDestination `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BackupDestination{}, &BackupDestinationList{})
+}
@@ -0,0 +1,4 @@
+// Package v1alpha1 contains the API types of the image clone controller
+// +k8s:deepcopy-gen=package,register
+// +groupName=imageclone.io
+package v1alpha1
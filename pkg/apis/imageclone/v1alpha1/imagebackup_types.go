@@ -0,0 +1,144 @@
+package v1alpha1
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// maximum length of the readable part of the ImageBackup name
+	maxNamePrefixLength = 50
+	// number of hex characters of the source hash in the ImageBackup name
+	nameHashLength = 10
+)
+
+// ImageBackupSpec defines the image to back up
+type ImageBackupSpec struct {
+	// Source is the reference of the image to back up
+	Source string `json:"source"`
+	// Namespace whose BackupDestination the image is backed up to, the global backup registry is used if empty
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ImageBackupStatus defines the observed state of the image backup
+type ImageBackupStatus struct {
+	// Source is the reference of the last backed up image
+	Source string `json:"source,omitempty"`
+	// Destination is the reference of the backup image
+	Destination string `json:"destination,omitempty"`
+	// Replicas are the references of the copies of the image in all the backup registries, Destination included
+	Replicas []string `json:"replicas,omitempty"`
+	// Digest of the backup image manifest
+	Digest string `json:"digest,omitempty"`
+	// SupersededDigests are the digests of the backup image manifests replaced by the tag re-sync, deleted with the backup image
+	SupersededDigests []string `json:"supersededDigests,omitempty"`
+	// Size of the backup image in bytes
+	Size int64 `json:"size,omitempty"`
+	// SourceDigest is the digest of the source image manifest at the last copy or check
+	SourceDigest string `json:"sourceDigest,omitempty"`
+	// LastSyncTime is the time of the last successful copy
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// LastCheckTime is the time the source image was last compared to the backup image (mutable tags only)
+	LastCheckTime *metav1.Time `json:"lastCheckTime,omitempty"`
+	// LastAttemptTime is the time of the last copy attempt
+	LastAttemptTime *metav1.Time `json:"lastAttemptTime,omitempty"`
+	// CopyAttempts is the number of copies attempted so far
+	CopyAttempts int `json:"copyAttempts,omitempty"`
+	// LastError is the error of the last failed copy, empty if the last copy succeeded
+	LastError string `json:"lastError,omitempty"`
+	// Workloads is the list of the workloads using either the source or the destination image
+	Workloads []WorkloadReference `json:"workloads,omitempty"`
+	// UnreferencedSince is the time the image stopped being used by any workload, nil while it's used
+	UnreferencedSince *metav1.Time `json:"unreferencedSince,omitempty"`
+}
+
+// WorkloadReference identifies a workload
+type WorkloadReference struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ImageBackup tracks the backup of a source image
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=imagebackups,scope=Cluster
+type ImageBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ImageBackupSpec   `json:"spec,omitempty"`
+	Status ImageBackupStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ImageBackupList contains a list of ImageBackup
+type ImageBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImageBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImageBackup{}, &ImageBackupList{})
+}
+
+// BackedUp returns true if the source image has been successfully copied to the destination at least once
+func (b *ImageBackup) BackedUp() bool {
+	return b.Status.Source == b.Spec.Source && b.Status.Destination != "" && b.Status.LastSyncTime != nil
+}
+
+// ReplicaImages returns the references of the copies of the image in all the backup registries,
+// only the destination for the backups made before these were tracked
+func (b *ImageBackup) ReplicaImages() []string {
+	if len(b.Status.Replicas) == 0 && b.Status.Destination != "" {
+		return []string{b.Status.Destination}
+	}
+	return b.Status.Replicas
+}
+
+// MutableTag returns true if the source image is referenced by a tag which may be moved to another image, i.e. not by digest
+func (b *ImageBackup) MutableTag() bool {
+	return !strings.Contains(b.Spec.Source, "@")
+}
+
+// NamespaceImageBackupName returns the name of the ImageBackup for the given source image
+// backed up to the BackupDestination of the given namespace, the global one if the namespace is empty
+func NamespaceImageBackupName(namespace, source string) string {
+	if namespace == "" {
+		return ImageBackupName(source)
+	}
+	return ImageBackupName(namespace + "/" + strings.TrimSpace(source))
+}
+
+// ImageBackupName returns the name of the ImageBackup for the given source image:
+// a readable part derived from the image followed by a hash of the image to stay unique
+func ImageBackupName(source string) string {
+	source = strings.TrimSpace(source)
+	prefix := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, source)
+	if len(prefix) > maxNamePrefixLength {
+		prefix = prefix[:maxNamePrefixLength]
+	}
+	prefix = strings.Trim(prefix, "-.")
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(source)))[:nameHashLength]
+	if prefix == "" {
+		return hash
+	}
+	return prefix + "-" + hash
+}
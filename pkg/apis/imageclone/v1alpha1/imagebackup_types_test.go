@@ -0,0 +1,177 @@
+package v1alpha1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestImageBackupName(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          string
+		expectedPrefix string
+	}{
+		{
+			name:           "Official image",
+			input:          "nginx",
+			expectedPrefix: "nginx-",
+		},
+		{
+			name:           "Full name",
+			input:          "quay.io/kubermatic/openvpn:v0.5",
+			expectedPrefix: "quay.io-kubermatic-openvpn-v0.5-",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := ImageBackupName(tc.input)
+			if errs := validation.IsDNS1123Subdomain(output); len(errs) != 0 {
+				t.Errorf("Invalid name %q: %v", output, errs)
+			}
+			if !strings.HasPrefix(output, tc.expectedPrefix) || len(output) != len(tc.expectedPrefix)+nameHashLength {
+				t.Errorf("Expected %q followed by the hash, got %q", tc.expectedPrefix, output)
+			}
+		})
+	}
+}
+
+func TestImageBackupNameValid(t *testing.T) {
+	inputs := []string{
+		"UPPER/Case:Tag",
+		"registry.example.com:5000/very/long/path/to/the/image/which/does/not/fit/in/the/name:1.0.0",
+		"gcr.io/team/app@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		"/:@",
+	}
+	seen := map[string]bool{}
+	for _, input := range inputs {
+		output := ImageBackupName(input)
+		if errs := validation.IsDNS1123Subdomain(output); len(errs) != 0 {
+			t.Errorf("Invalid name %q for %q: %v", output, input, errs)
+		}
+		if seen[output] {
+			t.Errorf("Duplicated name %q", output)
+		}
+		seen[output] = true
+	}
+}
+
+func TestNamespaceImageBackupName(t *testing.T) {
+	if output := NamespaceImageBackupName("", "nginx"); output != ImageBackupName("nginx") {
+		t.Errorf("Expected the global name %q, got %q", ImageBackupName("nginx"), output)
+	}
+	tenantA, tenantB := NamespaceImageBackupName("tenant-a", "nginx"), NamespaceImageBackupName("tenant-b", "nginx")
+	if tenantA == tenantB || tenantA == ImageBackupName("nginx") {
+		t.Errorf("Expected distinct names per namespace, got %q and %q", tenantA, tenantB)
+	}
+	if !strings.HasPrefix(tenantA, "tenant-a-nginx-") {
+		t.Errorf("Expected the namespace in the name, got %q", tenantA)
+	}
+}
+
+func TestBackedUp(t *testing.T) {
+	now := metav1.Now()
+	testCases := []struct {
+		name     string
+		input    *ImageBackup
+		expected bool
+	}{
+		{
+			name:     "New",
+			input:    &ImageBackup{Spec: ImageBackupSpec{Source: "nginx"}},
+			expected: false,
+		},
+		{
+			name: "Backed up",
+			input: &ImageBackup{
+				Spec:   ImageBackupSpec{Source: "nginx"},
+				Status: ImageBackupStatus{Source: "nginx", Destination: "quay.io/org/nginx", LastSyncTime: &now},
+			},
+			expected: true,
+		},
+		{
+			name: "Failed",
+			input: &ImageBackup{
+				Spec:   ImageBackupSpec{Source: "nginx"},
+				Status: ImageBackupStatus{CopyAttempts: 1, LastError: "timeout"},
+			},
+			expected: false,
+		},
+		{
+			name: "Source changed",
+			input: &ImageBackup{
+				Spec:   ImageBackupSpec{Source: "nginx:1.19"},
+				Status: ImageBackupStatus{Source: "nginx", Destination: "quay.io/org/nginx", LastSyncTime: &now},
+			},
+			expected: false,
+		},
+		{
+			name: "Failed resync",
+			input: &ImageBackup{
+				Spec:   ImageBackupSpec{Source: "nginx"},
+				Status: ImageBackupStatus{Source: "nginx", Destination: "quay.io/org/nginx", LastSyncTime: &now, LastError: "timeout"},
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := tc.input.BackedUp()
+			if output != tc.expected {
+				t.Errorf("Expected %t, got %t", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestMutableTag(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected bool
+	}{
+		{name: "Tag", source: "nginx:1.19", expected: true},
+		{name: "Default tag", source: "quay.io/org/nginx", expected: true},
+		{name: "Digest", source: "nginx@sha256:0123456789abcdef", expected: false},
+		{name: "Tag and digest", source: "nginx:1.19@sha256:0123456789abcdef", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &ImageBackup{Spec: ImageBackupSpec{Source: tc.source}}
+			if output := b.MutableTag(); output != tc.expected {
+				t.Errorf("Expected %t, got %t", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestReplicaImages(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   ImageBackupStatus
+		expected []string
+	}{
+		{name: "Not backed up", status: ImageBackupStatus{}, expected: nil},
+		{name: "Destination only", status: ImageBackupStatus{Destination: "quay.io/org/nginx"}, expected: []string{"quay.io/org/nginx"}},
+		{
+			name:     "Replicas",
+			status:   ImageBackupStatus{Destination: "quay.io/org/nginx", Replicas: []string{"quay.io/org/nginx", "dr.corp.internal/backup/nginx"}},
+			expected: []string{"quay.io/org/nginx", "dr.corp.internal/backup/nginx"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &ImageBackup{Status: tc.status}
+			if output := b.ReplicaImages(); !reflect.DeepEqual(output, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, output)
+			}
+		})
+	}
+}
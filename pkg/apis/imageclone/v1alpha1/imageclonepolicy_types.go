@@ -0,0 +1,78 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ClusterPolicyName is the name of the only ImageClonePolicy taken into account
+	ClusterPolicyName = "cluster"
+)
+
+// ImageClonePolicySpec overrides the configuration of the controller, the fields which are not set keep their value from the flags
+type ImageClonePolicySpec struct {
+	// Registry is the backup image registry
+	Registry string `json:"registry,omitempty"`
+	// Organization is the backup image registry's organization
+	Organization string `json:"organization,omitempty"`
+	// ImageCopyTimeoutSeconds is the timeout for the copy of a single image
+	ImageCopyTimeoutSeconds int `json:"imageCopyTimeoutSeconds,omitempty"`
+	// NamespaceBlacklist is the list of the namespaces which should NOT be watched in addition to the mandatory ones
+	NamespaceBlacklist []string `json:"namespaceBlacklist,omitempty"`
+	// NamespaceWhitelist is the list of the namespaces which should be exclusively watched
+	NamespaceWhitelist []string `json:"namespaceWhitelist,omitempty"`
+	// NamespaceSelector is the label selector the namespaces should match to be watched
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// ImageRules decide which images should be backed up
+	ImageRules []ImageRule `json:"imageRules,omitempty"`
+}
+
+// ImageRule decides what to do with the images matching all its non empty fields
+type ImageRule struct {
+	// Registry is a glob matched against the image registry
+	Registry string `json:"registry,omitempty"`
+	// Repository is a glob matched against the image repository
+	Repository string `json:"repository,omitempty"`
+	// Tag is a regexp matched against the image tag
+	Tag string `json:"tag,omitempty"`
+	// Action to take for the matched images: backup, skip or warn
+	Action string `json:"action"`
+}
+
+// ImageClonePolicyStatus defines the observed state of the policy
+type ImageClonePolicyStatus struct {
+	// ObservedGeneration is the generation of the last processed spec
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Applied is true if the last processed spec is in effect
+	Applied bool `json:"applied,omitempty"`
+	// Error is the reason why the last processed spec was rejected
+	Error string `json:"error,omitempty"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ImageClonePolicy holds the configuration of the controller which can be changed without restart
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=imageclonepolicies,scope=Cluster
+type ImageClonePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ImageClonePolicySpec   `json:"spec,omitempty"`
+	Status ImageClonePolicyStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ImageClonePolicyList contains a list of ImageClonePolicy
+type ImageClonePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImageClonePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImageClonePolicy{}, &ImageClonePolicyList{})
+}
@@ -2,10 +2,16 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"strings"
+	"time"
+
+	"image-clone-controller/pkg/schedule"
 
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 func init() {
@@ -14,14 +20,72 @@ func init() {
 	pflag.StringVar(&GlobalConfig.Organization, "registry-org", "", "Backup image registry's organization.")
 	pflag.StringVar(&GlobalConfig.Username, "registry-username", "", "Username to access the backup image registry.")
 	pflag.StringVar(&GlobalConfig.Password, "registry-password", "", "Password to access the backup image registry.")
-	pflag.StringSliceVar(&GlobalConfig.AdditionalNamespaceBlacklist, "additional-namespace-blacklist", []string{}, "List of namespace(s) which should NOT be watched.")
+	pflag.StringVar(&GlobalConfig.RegistryCredentialsDir, "registry-credentials-dir", "", "Directory with the username and password files to access the backup image registry (e.g. a mounted Secret), reloaded when the files change. Takes precedence over the other credential sources.")
+	pflag.StringVar(&GlobalConfig.BackupRegistriesFile, "backup-registries", "", "Path to a YAML file with the list of the additional registries the images are backed up to, each with its credentials and priority. The workloads use the healthy registry with the lowest priority.")
+	pflag.StringSliceVar(&GlobalConfig.MigrateFrom, "migrate-from", []string{}, "Previous backup registries with their organization (e.g. docker.io/myorg): the backup images found there are copied to the backup registries under the same name and the workloads are updated to the copies.")
+	pflag.StringSliceVar(&GlobalConfig.AdditionalNamespaceBlacklist, "additional-namespace-blacklist", []string{}, "List of namespace(s) which should NOT be watched. Globs (e.g. ci-*) and regexps enclosed in slashes (e.g. /^preview-pr-[0-9]+$/) are accepted.")
+	pflag.StringSliceVar(&GlobalConfig.WhitelistedNamespaces, "namespace-whitelist", []string{}, "List of namespace(s) which should be exclusively watched, all namespaces are watched if empty. Same patterns as for the blacklist are accepted.")
+	pflag.StringVar(&GlobalConfig.NamespaceSelector, "namespace-selector", "", "Label selector the namespaces should match to be watched, Kubernetes syntax where !key=value stands for key!=value (e.g. image-clone=enabled,!team=platform).")
+	pflag.StringVar(&GlobalConfig.ImageRulesFile, "image-rules", "", "Path to a YAML file with the list of rules deciding which images should be backed up.")
 	pflag.IntVar(&GlobalConfig.ImageCopyTimeoutSeconds, "img-copy-timeout", defaultImageCopyTimeout, "Timeout for the copy of a single image to the backup registry (in seconds).")
+	pflag.StringVar(&GlobalConfig.HealthProbeBindAddress, "health-probe-addr", defaultHealthProbeBindAddress, "The address the health probes (/healthz and /readyz) bind to (e.g. :8081), 0 disables the probes.")
+	pflag.BoolVar(&GlobalConfig.LeaderElection, "enable-leader-election", false, "Enable leader election to run several replicas of the controller, only the leader reconciles the workloads.")
+	pflag.StringVar(&GlobalConfig.LeaderElectionNamespace, "leader-election-namespace", "", "Namespace of the leader election lock, defaults to the controller's namespace when running in-cluster.")
+	pflag.DurationVar(&GlobalConfig.LeaderElectionLeaseDuration, "leader-election-lease-duration", defaultLeaseDuration, "Duration the non-leader replicas wait before forcing to acquire the leadership.")
+	pflag.DurationVar(&GlobalConfig.LeaderElectionRenewDeadline, "leader-election-renew-deadline", defaultRenewDeadline, "Duration the leader retries refreshing the leadership before giving it up.")
+	pflag.DurationVar(&GlobalConfig.TagResyncInterval, "tag-resync-interval", 0, "Interval of the comparison of the backed up mutable tags (e.g. nginx:latest) with their upstream images, the changed images are copied again. 0 disables the re-sync.")
+	pflag.StringVar(&GlobalConfig.TagResyncPolicy, "tag-resync-policy", TagResyncBackupOnly, "What to do when a mutable tag changed upstream: backup-only to refresh the backup image, rollout to refresh it and restart the workloads using it.")
+	pflag.DurationVar(&GlobalConfig.GCInterval, "gc-interval", 0, "Interval of the garbage collection of the backup images which are not used by any workload anymore, 0 disables the garbage collection.")
+	pflag.DurationVar(&GlobalConfig.GCGracePeriod, "gc-grace-period", defaultGCGracePeriod, "Time a backup image has to stay unused before being garbage collected.")
+	pflag.IntVar(&GlobalConfig.GCKeepLast, "gc-keep-last", defaultGCKeepLast, "Number of the most recently copied images kept in each backup repository, even if unused.")
+	pflag.BoolVar(&GlobalConfig.GCDryRun, "gc-dry-run", false, "Only report the backup images the garbage collection would delete.")
+	pflag.BoolVar(&GlobalConfig.AtomicMigration, "atomic-migration", false, "Update a workload only once all its images are backed up, so that it's rolled out once.")
+	pflag.IntVar(&GlobalConfig.MaxUpdatesPerMinute, "max-updates-per-minute", 0, "Maximum number of workload updates per minute for all the namespaces, 0 means no limit.")
+	pflag.IntVar(&GlobalConfig.MaxConcurrentRollouts, "max-concurrent-rollouts", 0, "Maximum number of workloads rolling out at the same time in a namespace before updating another one, 0 means no limit.")
+	pflag.StringArrayVar(&GlobalConfig.MaintenanceWindows, "maintenance-window", []string{}, "Window the workloads can be updated in: cron expression followed by a duration (e.g. '0 22 * * 1-5 4h'), in UTC. Can be repeated, the workloads can be updated at any time if none.")
+	pflag.StringVar(&GlobalConfig.OwnedWorkloads, "owned-workloads", OwnedWorkloadsSkip, "What to do with the workloads controlled by another object (ownerReferences): skip to leave them alone, backup-only to back up their images and report the changes without updating them.")
+	pflag.StringSliceVar(&GlobalConfig.AllowedOwnerKinds, "allowed-owner-kinds", []string{}, "Kinds of the owners whose workloads are safe to update like the workloads without owner (e.g. HelmRelease).")
+	pflag.IntVar(&GlobalConfig.MaxUpdateReverts, "max-update-reverts", defaultMaxUpdateReverts, "Number of reverts of the image updates of a workload (e.g. by a GitOps tool or an operator) within --update-revert-window after which the workload is not updated anymore, 0 disables the detection.")
+	pflag.DurationVar(&GlobalConfig.UpdateRevertWindow, "update-revert-window", defaultUpdateRevertWindow, "Time window the reverts of the image updates of a workload are counted in.")
+	pflag.BoolVar(&GlobalConfig.FailoverMode, "failover-mode", false, "Back up the images but keep the workloads on the upstream images, a workload is switched to the backup images only once its pods fail to pull the upstream ones.")
+	pflag.BoolVar(&GlobalConfig.FailoverSwitchBack, "failover-switch-back", false, "Switch the failed over workloads back to the upstream images once these are available again, requires --failover-mode.")
+	pflag.BoolVar(&GlobalConfig.VerifyRollouts, "verify-rollouts", false, "Watch the rollout of the migrated workloads and emit an Event when their pods fail to pull the backup images.")
+	pflag.BoolVar(&GlobalConfig.RollbackOnPullFailure, "rollback-on-pull-failure", false, "Revert the images of the migrated workloads to the original ones when their pods fail to pull the backup images, requires --verify-rollouts.")
+	pflag.BoolVar(&GlobalConfig.ManagePullSecrets, "manage-pull-secrets", false, "Maintain a pull Secret for the backup registry in each processed namespace and add it to the imagePullSecrets of the migrated workloads.")
+	pflag.StringVar(&GlobalConfig.PullSecretName, "pull-secret-name", defaultPullSecretName, "Name of the pull Secret maintained when --manage-pull-secrets is set.")
+	pflag.StringVar(&GlobalConfig.MetricsBindAddress, "metrics-addr", defaultMetricsBindAddress, "The address the Prometheus metrics endpoint binds to (e.g. :8080), 0 disables the metrics.")
 }
 
+const (
+	// OwnedWorkloadsSkip means that the workloads controlled by another object are left alone
+	OwnedWorkloadsSkip = "skip"
+	// OwnedWorkloadsBackupOnly means that the images of the workloads controlled by another object are backed up
+	// but the workloads are not updated
+	OwnedWorkloadsBackupOnly = "backup-only"
+)
+
+const (
+	// TagResyncBackupOnly means that only the backup image is refreshed when a mutable tag changed upstream
+	TagResyncBackupOnly = "backup-only"
+	// TagResyncRollout means that the workloads using the backup image are restarted once it's refreshed
+	TagResyncRollout = "rollout"
+)
+
 const (
 	usernameVar             = "IMG_CTR_REGISTRY_USERNAME"
 	passwordVar             = "IMG_CTR_REGISTRY_PASSWORD"
 	defaultImageCopyTimeout = 60 * 60
+	// metrics are disabled by default
+	defaultMetricsBindAddress = "0"
+	// health probes are disabled by default
+	defaultHealthProbeBindAddress = "0"
+	defaultPullSecretName         = "image-clone-pull-secret"
+	defaultMaxUpdateReverts       = 3
+	defaultGCGracePeriod          = 7 * 24 * time.Hour
+	defaultGCKeepLast             = 3
+	defaultUpdateRevertWindow     = 10 * time.Minute
+	defaultLeaseDuration          = 15 * time.Second
+	defaultRenewDeadline          = 10 * time.Second
 )
 
 // GlobalConfig is all program's config
@@ -35,9 +99,43 @@ type Config struct {
 	Organization                 string
 	Username                     string
 	Password                     string
+	RegistryCredentialsDir       string
 	ImageCopyTimeoutSeconds      int
+	BackupRegistriesFile         string
+	BackupRegistries             []BackupRegistry
+	MigrateFrom                  []string
 	MandatoryNamespaceBlacklist  []string
 	AdditionalNamespaceBlacklist []string
+	WhitelistedNamespaces        []string
+	NamespaceSelector            string
+	ImageRulesFile               string
+	ImageRules                   []ImageRule
+	TagResyncInterval            time.Duration
+	TagResyncPolicy              string
+	GCInterval                   time.Duration
+	GCGracePeriod                time.Duration
+	GCKeepLast                   int
+	GCDryRun                     bool
+	AtomicMigration              bool
+	MaxUpdatesPerMinute          int
+	MaxConcurrentRollouts        int
+	MaintenanceWindows           []string
+	OwnedWorkloads               string
+	AllowedOwnerKinds            []string
+	MaxUpdateReverts             int
+	UpdateRevertWindow           time.Duration
+	FailoverMode                 bool
+	FailoverSwitchBack           bool
+	VerifyRollouts               bool
+	RollbackOnPullFailure        bool
+	ManagePullSecrets            bool
+	PullSecretName               string
+	MetricsBindAddress           string
+	HealthProbeBindAddress       string
+	LeaderElection               bool
+	LeaderElectionNamespace      string
+	LeaderElectionLeaseDuration  time.Duration
+	LeaderElectionRenewDeadline  time.Duration
 }
 
 // Validate validates the important fields of the configuration
@@ -50,6 +148,14 @@ func (c *Config) Validate() error {
 		return errors.New("no organization for backup image registry provided")
 	}
 
+	if len(strings.TrimSpace(c.RegistryCredentialsDir)) != 0 {
+		username, password, err := readCredentials(c.RegistryCredentialsDir)
+		if err != nil {
+			return fmt.Errorf("failed to read the registry credentials: %v", err)
+		}
+		c.Username, c.Password = username, password
+	}
+
 	if len(strings.TrimSpace(c.Username)) == 0 {
 		c.Username = os.Getenv(usernameVar)
 		if len(strings.TrimSpace(c.Username)) == 0 {
@@ -64,17 +170,183 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if len(strings.TrimSpace(c.BackupRegistriesFile)) != 0 {
+		registries, err := loadBackupRegistries(c.BackupRegistriesFile)
+		if err != nil {
+			return fmt.Errorf("failed to load the backup registries: %v", err)
+		}
+		c.BackupRegistries = registries
+	}
+	destinations := map[string]bool{c.Registry + "/" + c.Organization: true}
+	for i := range c.BackupRegistries {
+		r := &c.BackupRegistries[i]
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("invalid backup registry: %v", err)
+		}
+		if destinations[r.Registry+"/"+r.Organization] {
+			return fmt.Errorf("backup registry %s/%s is listed twice", r.Registry, r.Organization)
+		}
+		destinations[r.Registry+"/"+r.Organization] = true
+	}
+	for _, previous := range c.MigrateFrom {
+		parts := strings.Split(previous, "/")
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 || len(strings.TrimSpace(parts[1])) == 0 {
+			return fmt.Errorf("invalid previous backup registry %q, expected registry/organization", previous)
+		}
+		if destinations[previous] {
+			return fmt.Errorf("previous backup registry %s is a current one", previous)
+		}
+	}
+
+	if _, err := NewNamespaceMatcher(append(append([]string{}, c.MandatoryNamespaceBlacklist...), c.AdditionalNamespaceBlacklist...)); err != nil {
+		return fmt.Errorf("invalid namespace blacklist: %v", err)
+	}
+
+	if _, err := NewNamespaceMatcher(c.WhitelistedNamespaces); err != nil {
+		return fmt.Errorf("invalid namespace whitelist: %v", err)
+	}
+
+	if _, err := ParseLabelSelector(c.NamespaceSelector); err != nil {
+		return fmt.Errorf("invalid namespace selector: %v", err)
+	}
+
+	if len(strings.TrimSpace(c.ImageRulesFile)) != 0 {
+		rules, err := loadImageRules(c.ImageRulesFile)
+		if err != nil {
+			return fmt.Errorf("failed to load image rules: %v", err)
+		}
+		c.ImageRules = rules
+	}
+
+	if _, err := NewImageRuleSet(c.ImageRules); err != nil {
+		return fmt.Errorf("invalid image rules: %v", err)
+	}
+
+	if c.TagResyncInterval < 0 {
+		return errors.New("tag re-sync interval should not be negative")
+	}
+	if len(strings.TrimSpace(c.TagResyncPolicy)) == 0 {
+		c.TagResyncPolicy = TagResyncBackupOnly
+	}
+	if c.TagResyncPolicy != TagResyncBackupOnly && c.TagResyncPolicy != TagResyncRollout {
+		return fmt.Errorf("invalid tag re-sync policy %q, expected %s or %s", c.TagResyncPolicy, TagResyncBackupOnly, TagResyncRollout)
+	}
+
+	if c.GCInterval < 0 || c.GCGracePeriod < 0 || c.GCKeepLast < 0 {
+		return errors.New("garbage collection settings should not be negative")
+	}
+
+	if c.MaxUpdatesPerMinute < 0 || c.MaxConcurrentRollouts < 0 {
+		return errors.New("workload update limits should not be negative")
+	}
+
+	if _, err := schedule.ParseWindows(c.MaintenanceWindows); err != nil {
+		return fmt.Errorf("invalid maintenance windows: %v", err)
+	}
+
+	if len(strings.TrimSpace(c.OwnedWorkloads)) == 0 {
+		c.OwnedWorkloads = OwnedWorkloadsSkip
+	}
+	if c.OwnedWorkloads != OwnedWorkloadsSkip && c.OwnedWorkloads != OwnedWorkloadsBackupOnly {
+		return fmt.Errorf("invalid owned workloads mode %q, expected %s or %s", c.OwnedWorkloads, OwnedWorkloadsSkip, OwnedWorkloadsBackupOnly)
+	}
+
+	if c.MaxUpdateReverts < 0 {
+		return errors.New("maximum number of update reverts should not be negative")
+	}
+	if c.MaxUpdateReverts > 0 && c.UpdateRevertWindow <= 0 {
+		return errors.New("update revert window should be positive")
+	}
+
+	if c.FailoverSwitchBack && !c.FailoverMode {
+		return errors.New("switching back to the upstream images requires the failover mode")
+	}
+
+	if c.RollbackOnPullFailure && !c.VerifyRollouts {
+		return errors.New("rollback on pull failure requires the rollouts to be verified")
+	}
+
+	if c.ManagePullSecrets {
+		if errs := validation.IsDNS1123Subdomain(c.PullSecretName); len(errs) != 0 {
+			return fmt.Errorf("invalid pull secret name: %s", strings.Join(errs, ", "))
+		}
+	}
+
+	if c.LeaderElection {
+		if c.LeaderElectionLeaseDuration <= 0 || c.LeaderElectionRenewDeadline <= 0 {
+			return errors.New("leader election lease duration and renew deadline should be positive")
+		}
+		if c.LeaderElectionRenewDeadline >= c.LeaderElectionLeaseDuration {
+			return errors.New("leader election renew deadline should be less than the lease duration")
+		}
+	}
+
 	return nil
 }
 
-// NamespaceBlacklist returns a set of all blacklisted namespaces
-func (c *Config) NamespaceBlacklist() map[string]bool {
-	set := map[string]bool{}
-	for _, n := range c.MandatoryNamespaceBlacklist {
-		set[n] = true
+// OwnerKindAllowed returns true if the workloads controlled by an owner of the given kind can be updated
+func (c *Config) OwnerKindAllowed(kind string) bool {
+	for _, k := range c.AllowedOwnerKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// PullFailuresWatched returns true if the image pull failures of the pods have to be watched
+func (c *Config) PullFailuresWatched() bool {
+	return c.VerifyRollouts || c.FailoverMode
+}
+
+// NamespaceBlacklist returns a matcher for all blacklisted namespaces
+func (c *Config) NamespaceBlacklist() *NamespaceMatcher {
+	patterns := append(append([]string{}, c.MandatoryNamespaceBlacklist...), c.AdditionalNamespaceBlacklist...)
+	m, err := NewNamespaceMatcher(patterns)
+	if err != nil {
+		// should have been caught by the validation
+		return &NamespaceMatcher{}
+	}
+	return m
+}
+
+// NamespaceWhitelist returns a matcher for all whitelisted namespaces
+func (c *Config) NamespaceWhitelist() *NamespaceMatcher {
+	m, err := NewNamespaceMatcher(c.WhitelistedNamespaces)
+	if err != nil {
+		// should have been caught by the validation
+		return &NamespaceMatcher{}
 	}
-	for _, n := range c.AdditionalNamespaceBlacklist {
-		set[n] = true
+	return m
+}
+
+// NamespaceLabelSelector returns the label selector the watched namespaces should match,
+// the selector matches everything if none was provided
+func (c *Config) NamespaceLabelSelector() labels.Selector {
+	selector, err := ParseLabelSelector(c.NamespaceSelector)
+	if err != nil {
+		// should have been caught by the validation
+		return labels.Nothing()
+	}
+	return selector
+}
+
+// MaintenanceSchedule returns the windows the workloads can be updated in, empty if they can be updated at any time
+func (c *Config) MaintenanceSchedule() schedule.Windows {
+	windows, err := schedule.ParseWindows(c.MaintenanceWindows)
+	if err != nil {
+		// should have been caught by the validation
+		return schedule.Windows{}
+	}
+	return windows
+}
+
+// ImageRuleSet returns the rules deciding which images should be backed up
+func (c *Config) ImageRuleSet() *ImageRuleSet {
+	set, err := NewImageRuleSet(c.ImageRules)
+	if err != nil {
+		// should have been caught by the validation
+		return &ImageRuleSet{}
 	}
 	return set
 }
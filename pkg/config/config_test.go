@@ -2,8 +2,10 @@ package config
 
 import (
 	"os"
-	"reflect"
 	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 func TestValidateConfig(t *testing.T) {
@@ -51,6 +53,156 @@ func TestValidateConfig(t *testing.T) {
 			pwdVar:        true,
 			expectedError: false,
 		},
+		{
+			name:          "Invalid namespace blacklist",
+			input:         withNamespaceBlacklist(newTestConfig("1", "1", "1", "1"), "ci-["),
+			expectedError: true,
+		},
+		{
+			name:          "Leader election",
+			input:         withLeaderElection(newTestConfig("1", "1", "1", "1"), 15*time.Second, 10*time.Second),
+			expectedError: false,
+		},
+		{
+			name:          "Leader election renew deadline too long",
+			input:         withLeaderElection(newTestConfig("1", "1", "1", "1"), 10*time.Second, 10*time.Second),
+			expectedError: true,
+		},
+		{
+			name:          "Leader election no lease duration",
+			input:         withLeaderElection(newTestConfig("1", "1", "1", "1"), 0, 10*time.Second),
+			expectedError: true,
+		},
+		{
+			name:          "Namespace selector",
+			input:         withNamespaceSelector(newTestConfig("1", "1", "1", "1"), "image-clone=enabled,team!=platform"),
+			expectedError: false,
+		},
+		{
+			name:          "Negated namespace selector",
+			input:         withNamespaceSelector(newTestConfig("1", "1", "1", "1"), "image-clone=enabled,!team=platform"),
+			expectedError: false,
+		},
+		{
+			name:          "Invalid namespace selector",
+			input:         withNamespaceSelector(newTestConfig("1", "1", "1", "1"), "team in (platform"),
+			expectedError: true,
+		},
+		{
+			name:          "Negative update limit",
+			input:         withUpdateLimits(newTestConfig("1", "1", "1", "1"), -1, 0),
+			expectedError: true,
+		},
+		{
+			name:          "Tag re-sync",
+			input:         withTagResync(newTestConfig("1", "1", "1", "1"), time.Hour, TagResyncRollout),
+			expectedError: false,
+		},
+		{
+			name:          "Invalid tag re-sync policy",
+			input:         withTagResync(newTestConfig("1", "1", "1", "1"), time.Hour, "restart"),
+			expectedError: true,
+		},
+		{
+			name:          "Garbage collection",
+			input:         withGC(newTestConfig("1", "1", "1", "1"), time.Hour, 24*time.Hour, 3),
+			expectedError: false,
+		},
+		{
+			name:          "Negative number of kept images",
+			input:         withGC(newTestConfig("1", "1", "1", "1"), time.Hour, 24*time.Hour, -1),
+			expectedError: true,
+		},
+		{
+			name:          "Maintenance windows",
+			input:         withMaintenanceWindows(newTestConfig("1", "1", "1", "1"), "0 22 * * 1-5 4h", "0 10 * * 6,0 2h"),
+			expectedError: false,
+		},
+		{
+			name:          "Invalid maintenance window",
+			input:         withMaintenanceWindows(newTestConfig("1", "1", "1", "1"), "0 22 * * 1-5"),
+			expectedError: true,
+		},
+		{
+			name:          "Owned workloads backed up only",
+			input:         withOwnedWorkloads(newTestConfig("1", "1", "1", "1"), OwnedWorkloadsBackupOnly),
+			expectedError: false,
+		},
+		{
+			name:          "Invalid owned workloads mode",
+			input:         withOwnedWorkloads(newTestConfig("1", "1", "1", "1"), "update"),
+			expectedError: true,
+		},
+		{
+			name:          "Update revert detection",
+			input:         withUpdateReverts(newTestConfig("1", "1", "1", "1"), 3, time.Minute),
+			expectedError: false,
+		},
+		{
+			name:          "Update revert detection without window",
+			input:         withUpdateReverts(newTestConfig("1", "1", "1", "1"), 3, 0),
+			expectedError: true,
+		},
+		{
+			name:          "Failover mode with switch back",
+			input:         withFailover(newTestConfig("1", "1", "1", "1"), true, true),
+			expectedError: false,
+		},
+		{
+			name:          "Switch back without failover mode",
+			input:         withFailover(newTestConfig("1", "1", "1", "1"), false, true),
+			expectedError: true,
+		},
+		{
+			name:          "Rollback on pull failure",
+			input:         withRolloutVerification(newTestConfig("1", "1", "1", "1"), true, true),
+			expectedError: false,
+		},
+		{
+			name:          "Rollback without rollout verification",
+			input:         withRolloutVerification(newTestConfig("1", "1", "1", "1"), false, true),
+			expectedError: true,
+		},
+		{
+			name:          "Backup registries",
+			input:         withBackupRegistries(newTestConfig("1", "1", "1", "1"), BackupRegistry{Registry: "2", Organization: "2", Username: "2", Password: "2", Priority: 1}),
+			expectedError: false,
+		},
+		{
+			name:          "Backup registry without credentials",
+			input:         withBackupRegistries(newTestConfig("1", "1", "1", "1"), BackupRegistry{Registry: "2", Organization: "2"}),
+			expectedError: true,
+		},
+		{
+			name:          "Backup registry listed twice",
+			input:         withBackupRegistries(newTestConfig("1", "1", "1", "1"), BackupRegistry{Registry: "1", Organization: "1", Username: "2", Password: "2"}),
+			expectedError: true,
+		},
+		{
+			name:          "Migration from a previous backup registry",
+			input:         withMigrateFrom(newTestConfig("1", "1", "1", "1"), "docker.io/old"),
+			expectedError: false,
+		},
+		{
+			name:          "Invalid previous backup registry",
+			input:         withMigrateFrom(newTestConfig("1", "1", "1", "1"), "docker.io"),
+			expectedError: true,
+		},
+		{
+			name:          "Migration from the current backup registry",
+			input:         withMigrateFrom(newTestConfig("1", "1", "1", "1"), "1/1"),
+			expectedError: true,
+		},
+		{
+			name:          "Pull secrets",
+			input:         withPullSecrets(newTestConfig("1", "1", "1", "1"), "image-clone-pull-secret"),
+			expectedError: false,
+		},
+		{
+			name:          "Invalid pull secret name",
+			input:         withPullSecrets(newTestConfig("1", "1", "1", "1"), "Pull_Secret"),
+			expectedError: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -81,18 +233,18 @@ func TestValidateConfig(t *testing.T) {
 
 func TestNamespaceBlacklist(t *testing.T) {
 	testCases := []struct {
-		name     string
-		config   *Config
-		expected map[string]bool
+		name       string
+		config     *Config
+		matched    []string
+		notMatched []string
 	}{
 		{
 			name: "Nominal",
 			config: &Config{
 				MandatoryNamespaceBlacklist: []string{"must"},
 			},
-			expected: map[string]bool{
-				"must": true,
-			},
+			matched:    []string{"must"},
+			notMatched: []string{"should"},
 		},
 		{
 			name: "Nominal with additional",
@@ -100,10 +252,8 @@ func TestNamespaceBlacklist(t *testing.T) {
 				MandatoryNamespaceBlacklist:  []string{"must"},
 				AdditionalNamespaceBlacklist: []string{"should"},
 			},
-			expected: map[string]bool{
-				"must":   true,
-				"should": true,
-			},
+			matched:    []string{"must", "should"},
+			notMatched: []string{"other"},
 		},
 		{
 			name: "Duplication",
@@ -111,19 +261,128 @@ func TestNamespaceBlacklist(t *testing.T) {
 				MandatoryNamespaceBlacklist:  []string{"must"},
 				AdditionalNamespaceBlacklist: []string{"must", "should", "shouldtoo", "should"},
 			},
-			expected: map[string]bool{
-				"must":      true,
-				"should":    true,
-				"shouldtoo": true,
+			matched:    []string{"must", "should", "shouldtoo"},
+			notMatched: []string{"other"},
+		},
+		{
+			name: "Patterns",
+			config: &Config{
+				MandatoryNamespaceBlacklist:  []string{"kube-system"},
+				AdditionalNamespaceBlacklist: []string{"ci-*", "*-system", "/preview-pr-[0-9]+/"},
 			},
+			matched:    []string{"kube-system", "ci-123", "monitoring-system", "preview-pr-42"},
+			notMatched: []string{"ci", "system", "preview-pr-42-db", "default"},
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			output := tc.config.NamespaceBlacklist()
-			if !reflect.DeepEqual(output, tc.expected) {
-				t.Errorf("Expected %+v, got %+v", tc.expected, output)
+			for _, ns := range tc.matched {
+				if !output.Matches(ns) {
+					t.Errorf("Expected %q to be blacklisted", ns)
+				}
+			}
+			for _, ns := range tc.notMatched {
+				if output.Matches(ns) {
+					t.Errorf("Expected %q not to be blacklisted", ns)
+				}
+			}
+		})
+	}
+}
+
+func TestNewNamespaceMatcher(t *testing.T) {
+	testCases := []struct {
+		name          string
+		patterns      []string
+		expectedEmpty bool
+		expectedError bool
+	}{
+		{
+			name:          "Empty",
+			patterns:      []string{"", " "},
+			expectedEmpty: true,
+		},
+		{
+			name:     "Valid",
+			patterns: []string{"exact", "glob-*", "/^re(gexp)?$/"},
+		},
+		{
+			name:          "Invalid glob",
+			patterns:      []string{"glob-["},
+			expectedError: true,
+		},
+		{
+			name:          "Invalid regexp",
+			patterns:      []string{"/re(gexp/"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := NewNamespaceMatcher(tc.patterns)
+			if err != nil {
+				if !tc.expectedError {
+					t.Errorf("Got not expected error: %v", err)
+				}
+				return
+			}
+			if tc.expectedError {
+				t.Error("Got no error while one is expected")
+			}
+			if output.Empty() != tc.expectedEmpty {
+				t.Errorf("Expected empty %t, got %t", tc.expectedEmpty, output.Empty())
+			}
+		})
+	}
+}
+
+func TestParseLabelSelector(t *testing.T) {
+	testCases := []struct {
+		name        string
+		selector    string
+		expected    string
+		matching    map[string]string
+		notMatching map[string]string
+	}{
+		{
+			name:        "Negated equality",
+			selector:    "!team=platform",
+			expected:    "team!=platform",
+			matching:    map[string]string{"team": "web"},
+			notMatching: map[string]string{"team": "platform"},
+		},
+		{
+			name:        "Mixed",
+			selector:    "image-clone=enabled, !team==platform,env in (dev,prod),!legacy",
+			expected:    "env in (dev,prod),image-clone=enabled,!legacy,team!=platform",
+			matching:    map[string]string{"image-clone": "enabled", "env": "dev"},
+			notMatching: map[string]string{"image-clone": "enabled", "env": "dev", "team": "platform"},
+		},
+		{
+			name:     "Empty",
+			selector: "",
+			expected: "",
+			matching: map[string]string{"team": "platform"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			selector, err := ParseLabelSelector(tc.selector)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if selector.String() != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, selector.String())
+			}
+			if !selector.Matches(labels.Set(tc.matching)) {
+				t.Errorf("Expected %v to match", tc.matching)
+			}
+			if tc.notMatching != nil && selector.Matches(labels.Set(tc.notMatching)) {
+				t.Errorf("Expected %v not to match", tc.notMatching)
 			}
 		})
 	}
@@ -140,3 +399,83 @@ func newTestConfig(reg, org, usr, pwd string) *Config {
 		AdditionalNamespaceBlacklist: []string{},
 	}
 }
+
+func withNamespaceSelector(c *Config, selector string) *Config {
+	c.NamespaceSelector = selector
+	return c
+}
+
+func withNamespaceBlacklist(c *Config, patterns ...string) *Config {
+	c.AdditionalNamespaceBlacklist = patterns
+	return c
+}
+
+func withUpdateLimits(c *Config, perMinute, concurrent int) *Config {
+	c.MaxUpdatesPerMinute = perMinute
+	c.MaxConcurrentRollouts = concurrent
+	return c
+}
+
+func withTagResync(c *Config, interval time.Duration, policy string) *Config {
+	c.TagResyncInterval = interval
+	c.TagResyncPolicy = policy
+	return c
+}
+
+func withGC(c *Config, interval, grace time.Duration, keepLast int) *Config {
+	c.GCInterval = interval
+	c.GCGracePeriod = grace
+	c.GCKeepLast = keepLast
+	return c
+}
+
+func withMaintenanceWindows(c *Config, windows ...string) *Config {
+	c.MaintenanceWindows = windows
+	return c
+}
+
+func withOwnedWorkloads(c *Config, mode string) *Config {
+	c.OwnedWorkloads = mode
+	return c
+}
+
+func withUpdateReverts(c *Config, maxReverts int, window time.Duration) *Config {
+	c.MaxUpdateReverts = maxReverts
+	c.UpdateRevertWindow = window
+	return c
+}
+
+func withFailover(c *Config, failover, switchBack bool) *Config {
+	c.FailoverMode = failover
+	c.FailoverSwitchBack = switchBack
+	return c
+}
+
+func withRolloutVerification(c *Config, verify, rollback bool) *Config {
+	c.VerifyRollouts = verify
+	c.RollbackOnPullFailure = rollback
+	return c
+}
+
+func withBackupRegistries(c *Config, registries ...BackupRegistry) *Config {
+	c.BackupRegistries = registries
+	return c
+}
+
+func withMigrateFrom(c *Config, previous ...string) *Config {
+	c.MigrateFrom = previous
+	return c
+}
+
+func withPullSecrets(c *Config, name string) *Config {
+	c.ManagePullSecrets = true
+	c.PullSecretName = name
+	return c
+}
+
+func withLeaderElection(c *Config, lease, renew time.Duration) *Config {
+	c.LeaderElection = true
+	c.LeaderElectionLeaseDuration = lease
+	c.LeaderElectionRenewDeadline = renew
+	return c
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	usernameFile = "username"
+	passwordFile = "password"
+	// kubelet refreshes the mounted Secrets every minute or so, reading the files is cheap:
+	// checking often puts the new credentials in effect soon after the refresh
+	credentialsCheckInterval = 10 * time.Second
+)
+
+var log = logf.Log.WithName("config")
+
+// readCredentials returns the username and the password from the files of the given directory
+func readCredentials(dir string) (string, string, error) {
+	username, err := ioutil.ReadFile(filepath.Join(dir, usernameFile))
+	if err != nil {
+		return "", "", err
+	}
+	password, err := ioutil.ReadFile(filepath.Join(dir, passwordFile))
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(string(username)), strings.TrimSpace(string(password)), nil
+}
+
+// CredentialsWatcher puts the registry credentials in effect each time they change in the credentials directories
+// of the backup registry and of the additional backup registries, or in the backup registries file
+type CredentialsWatcher struct {
+	interval time.Duration
+}
+
+// NewCredentialsWatcherFromConfig returns a watcher of the credentials directories from the program's config
+func NewCredentialsWatcherFromConfig() *CredentialsWatcher {
+	return &CredentialsWatcher{
+		interval: credentialsCheckInterval,
+	}
+}
+
+// CredentialsWatched returns true if the credentials of a backup registry are read from a directory or from the backup registries file
+func (c *Config) CredentialsWatched() bool {
+	if c.RegistryCredentialsDir != "" || c.BackupRegistriesFile != "" {
+		return true
+	}
+	for _, r := range c.BackupRegistries {
+		if r.CredentialsDir != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Start checks the credentials periodically until the stop channel is closed
+func (w *CredentialsWatcher) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if err := w.check(); err != nil {
+				log.Error(err, "Failed to reload the registry credentials")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection returns false: all the replicas need the valid credentials, e.g. for the readiness checks
+func (w *CredentialsWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// check applies the configuration again if the credentials of a backup registry changed,
+// the credentials and the backup registries file are read by the validation
+func (w *CredentialsWatcher) check() error {
+	c := Current()
+	changed, err := credentialsChanged(c.RegistryCredentialsDir, c.Username, c.Password)
+	if err != nil {
+		return err
+	}
+	registriesChanged, err := backupRegistriesChanged(c.BackupRegistriesFile, c.BackupRegistries)
+	if err != nil {
+		return err
+	}
+	changed = changed || registriesChanged
+	for _, r := range c.BackupRegistries {
+		mirrorChanged, err := credentialsChanged(r.CredentialsDir, r.Username, r.Password)
+		if err != nil {
+			return err
+		}
+		changed = changed || mirrorChanged
+	}
+	if !changed {
+		return nil
+	}
+
+	if err := Update(func(*Config) {}); err != nil {
+		return fmt.Errorf("new credentials rejected: %v", err)
+	}
+	log.Info("Registry credentials reloaded")
+	return nil
+}
+
+// credentialsChanged returns true if the credentials in the given directory are not the given ones, false if there is no directory
+func credentialsChanged(dir, username, password string) (bool, error) {
+	if dir == "" {
+		return false, nil
+	}
+	u, p, err := readCredentials(dir)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the credentials from %s: %v", dir, err)
+	}
+	return u != username || p != password, nil
+}
+
+// backupRegistriesChanged returns true if the backup registries listed in the given file, with the credentials of their directories,
+// are not the given ones, false if there is no file
+func backupRegistriesChanged(file string, registries []BackupRegistry) (bool, error) {
+	if file == "" {
+		return false, nil
+	}
+	listed, err := loadBackupRegistries(file)
+	if err != nil {
+		return false, fmt.Errorf("failed to load the backup registries: %v", err)
+	}
+	for i := range listed {
+		if err := listed[i].validate(); err != nil {
+			return false, fmt.Errorf("invalid backup registry: %v", err)
+		}
+	}
+	if len(listed) == 0 && len(registries) == 0 {
+		return false, nil
+	}
+	return !reflect.DeepEqual(listed, registries), nil
+}
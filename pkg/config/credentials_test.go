@@ -0,0 +1,145 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialsWatcher(t *testing.T) {
+	defer func() { current = nil }()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatalf("Failed to create the credentials directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestCredentials(t, dir, "user", "pwd\n")
+
+	c := newTestConfig("1", "1", "", "")
+	c.RegistryCredentialsDir = dir
+	if err := Apply(c); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	if Current().Username != "user" || Current().Password != "pwd" {
+		t.Fatalf("Expected the credentials from the files, got %q/%q", Current().Username, Current().Password)
+	}
+
+	w := &CredentialsWatcher{}
+	changes := Subscribe()
+	if err := w.check(); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	select {
+	case <-changes:
+		t.Errorf("Expected no notification for the same credentials")
+	default:
+	}
+
+	writeTestCredentials(t, dir, "user", "rotated")
+	if err := w.check(); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	if Current().Password != "rotated" {
+		t.Errorf("Expected the rotated password, got %q", Current().Password)
+	}
+	select {
+	case <-changes:
+	default:
+		t.Errorf("Expected a notification for the rotated credentials")
+	}
+
+	os.Remove(filepath.Join(dir, passwordFile))
+	if err := w.check(); err == nil {
+		t.Errorf("Got no error while one is expected")
+	}
+	if Current().Password != "rotated" {
+		t.Errorf("Expected the credentials in effect to be kept, got %q", Current().Password)
+	}
+}
+
+func TestCredentialsWatcherBackupRegistries(t *testing.T) {
+	defer func() { current = nil }()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatalf("Failed to create the credentials directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestCredentials(t, dir, "mirror-user", "pwd")
+
+	c := withBackupRegistries(newTestConfig("1", "1", "1", "1"),
+		BackupRegistry{Registry: "mirror.example.com", Organization: "org", CredentialsDir: dir, Priority: 1})
+	if err := Apply(c); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	if !Current().CredentialsWatched() {
+		t.Errorf("Expected the credentials directory of the additional registry to be watched")
+	}
+
+	w := &CredentialsWatcher{}
+	writeTestCredentials(t, dir, "mirror-user", "rotated")
+	if err := w.check(); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	if p := Current().BackupRegistries[0].Password; p != "rotated" {
+		t.Errorf("Expected the rotated password of the additional registry, got %q", p)
+	}
+	if Current().Password != "1" {
+		t.Errorf("Expected the credentials of the backup registry to be kept, got %q", Current().Password)
+	}
+}
+
+func TestCredentialsWatcherRegistriesFile(t *testing.T) {
+	defer func() { current = nil }()
+
+	dir, err := ioutil.TempDir("", "registries")
+	if err != nil {
+		t.Fatalf("Failed to create the registries directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "registries.yaml")
+	writeRegistries := func(password string) {
+		content := "- registry: mirror.example.com\n  organization: org\n  username: mirror-user\n  password: " + password + "\n  priority: 1\n"
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write the registries: %v", err)
+		}
+	}
+	writeRegistries("pwd")
+
+	c := newTestConfig("1", "1", "1", "1")
+	c.BackupRegistriesFile = file
+	if err := Apply(c); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	if !Current().CredentialsWatched() {
+		t.Errorf("Expected the backup registries file to be watched")
+	}
+
+	w := &CredentialsWatcher{}
+	_, generation := currentGeneration()
+	if err := w.check(); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	if _, g := currentGeneration(); g != generation {
+		t.Errorf("Expected the configuration not to be applied again without change")
+	}
+
+	writeRegistries("rotated")
+	if err := w.check(); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	if p := Current().BackupRegistries[0].Password; p != "rotated" {
+		t.Errorf("Expected the rotated password of the additional registry, got %q", p)
+	}
+}
+
+func writeTestCredentials(t *testing.T, dir, username, password string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, usernameFile), []byte(username), 0600); err != nil {
+		t.Fatalf("Failed to write the username: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, passwordFile), []byte(password), 0600); err != nil {
+		t.Fatalf("Failed to write the password: %v", err)
+	}
+}
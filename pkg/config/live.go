@@ -0,0 +1,154 @@
+package config
+
+import (
+	"sync"
+)
+
+var (
+	// current is the configuration in effect, GlobalConfig until another one is applied
+	current     *Config
+	currentLock sync.RWMutex
+	// generation is incremented each time a configuration is applied
+	generation uint64
+
+	subscribers     []chan struct{}
+	subscribersLock sync.Mutex
+)
+
+// Current returns the configuration currently in effect.
+// The returned configuration must not be modified: Apply a modified copy instead.
+func Current() *Config {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+	if current == nil {
+		return GlobalConfig
+	}
+	return current
+}
+
+// currentGeneration returns the configuration currently in effect with its generation
+func currentGeneration() (*Config, uint64) {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+	if current == nil {
+		return GlobalConfig, generation
+	}
+	return current, generation
+}
+
+// Apply validates the given configuration and puts it in effect, the subscribers are notified.
+// The configuration in effect is not changed if the given one is not valid.
+func Apply(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	currentLock.Lock()
+	current = c
+	generation++
+	currentLock.Unlock()
+
+	notify()
+	return nil
+}
+
+// Update puts in effect a copy of the configuration in effect modified by the given function, the subscribers are notified.
+// Unlike applying a modified copy of Current, the copy, the validation and the swap are atomic:
+// a configuration applied meanwhile can't be overwritten. The configuration in effect is not changed if the new one is not valid.
+func Update(change func(*Config)) error {
+	currentLock.Lock()
+	c := GlobalConfig
+	if current != nil {
+		c = current
+	}
+	c = c.Copy()
+	change(c)
+	if err := c.Validate(); err != nil {
+		currentLock.Unlock()
+		return err
+	}
+	current = c
+	generation++
+	currentLock.Unlock()
+
+	notify()
+	return nil
+}
+
+// Subscribe returns a channel which receives a value each time a new configuration is applied.
+// Notifications are coalesced: a slow subscriber gets one notification for several changes.
+func Subscribe() <-chan struct{} {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	ch := make(chan struct{}, 1)
+	subscribers = append(subscribers, ch)
+	return ch
+}
+
+// notify notifies all the subscribers without blocking
+func notify() {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	for _, ch := range subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// already notified
+		}
+	}
+}
+
+// Copy returns a deep copy of the configuration
+func (c *Config) Copy() *Config {
+	out := *c
+	out.BackupRegistries = append([]BackupRegistry{}, c.BackupRegistries...)
+	out.MigrateFrom = append([]string{}, c.MigrateFrom...)
+	out.MandatoryNamespaceBlacklist = append([]string{}, c.MandatoryNamespaceBlacklist...)
+	out.AdditionalNamespaceBlacklist = append([]string{}, c.AdditionalNamespaceBlacklist...)
+	out.WhitelistedNamespaces = append([]string{}, c.WhitelistedNamespaces...)
+	out.ImageRules = append([]ImageRule{}, c.ImageRules...)
+	out.MaintenanceWindows = append([]string{}, c.MaintenanceWindows...)
+	out.AllowedOwnerKinds = append([]string{}, c.AllowedOwnerKinds...)
+	return &out
+}
+
+// Derived is a value derived from the program's config, e.g. the matcher built from the namespace lists.
+// The value is built again only when another configuration is applied.
+type Derived struct {
+	// build derives the value from a configuration, nil if the value doesn't follow the program's config
+	build      func(*Config) interface{}
+	lock       sync.Mutex
+	generation uint64
+	value      interface{}
+}
+
+// NewDerived returns a value built by the given function from the configuration in effect,
+// the value follows the configuration changes
+func NewDerived(build func(*Config) interface{}) *Derived {
+	c, generation := currentGeneration()
+	return &Derived{
+		build:      build,
+		generation: generation,
+		value:      build(c),
+	}
+}
+
+// Fixed returns a value which doesn't follow the program's config
+func Fixed(value interface{}) *Derived {
+	return &Derived{value: value}
+}
+
+// Get returns the value, rebuilt if another configuration was applied since it was built. Nil for a nil Derived.
+func (d *Derived) Get() interface{} {
+	if d == nil {
+		return nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.build != nil {
+		if c, generation := currentGeneration(); generation != d.generation {
+			d.value, d.generation = d.build(c), generation
+		}
+	}
+	return d.value
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	defer func() { current = nil }()
+
+	if Current() != GlobalConfig {
+		t.Fatalf("Expected the global config to be in effect by default")
+	}
+	changes := Subscribe()
+
+	invalid := newTestConfig("", "1", "1", "1")
+	if err := Apply(invalid); err == nil {
+		t.Errorf("Expected an error for an invalid config")
+	}
+	if Current() != GlobalConfig {
+		t.Errorf("Expected an invalid config not to be applied")
+	}
+	select {
+	case <-changes:
+		t.Errorf("Expected no notification for an invalid config")
+	default:
+	}
+
+	valid := newTestConfig("1", "1", "1", "1")
+	if err := Apply(valid); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	if Current() != valid {
+		t.Errorf("Expected the valid config to be applied")
+	}
+	// notifications are coalesced
+	if err := Apply(valid); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	select {
+	case <-changes:
+	default:
+		t.Errorf("Expected a notification for the applied config")
+	}
+	select {
+	case <-changes:
+		t.Errorf("Expected a single notification")
+	default:
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	defer func() { current = nil }()
+
+	if err := Apply(newTestConfig("1", "1", "1", "1")); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	changes := Subscribe()
+
+	if err := Update(func(c *Config) { c.Registry = "" }); err == nil {
+		t.Errorf("Expected an error for an invalid change")
+	}
+	if Current().Registry != "1" {
+		t.Errorf("Expected an invalid change not to be applied")
+	}
+
+	previous := Current()
+	if err := Update(func(c *Config) { c.Organization = "2" }); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	if Current().Organization != "2" || Current().Registry != "1" {
+		t.Errorf("Expected the change to be applied to the config in effect, got %s/%s", Current().Registry, Current().Organization)
+	}
+	if previous.Organization != "1" {
+		t.Errorf("Expected the config in effect not to be modified in place")
+	}
+	select {
+	case <-changes:
+	default:
+		t.Errorf("Expected a notification for the change")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	c := withNamespaceBlacklist(newTestConfig("1", "1", "1", "1"), "ci-*")
+	c.ImageRules = []ImageRule{{Repository: "nvidia/*", Action: ImageActionSkip}}
+
+	out := c.Copy()
+	out.AdditionalNamespaceBlacklist[0] = "changed"
+	out.ImageRules[0].Action = ImageActionWarn
+	if c.AdditionalNamespaceBlacklist[0] != "ci-*" || c.ImageRules[0].Action != ImageActionSkip {
+		t.Errorf("Expected the copy not to share the slices with the original")
+	}
+}
+
+func TestDerived(t *testing.T) {
+	defer func() { current = nil }()
+
+	builds := 0
+	d := NewDerived(func(c *Config) interface{} {
+		builds++
+		return c.Registry
+	})
+	fixed := Fixed("fixed")
+	if d.Get() != GlobalConfig.Registry || builds != 1 {
+		t.Errorf("Expected the value to be built once from the config in effect, got %v after %d builds", d.Get(), builds)
+	}
+
+	if err := Apply(newTestConfig("registry.example.com", "1", "1", "1")); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if d.Get() != "registry.example.com" {
+			t.Errorf("Expected the value to follow the applied config, got %v", d.Get())
+		}
+	}
+	if builds != 2 {
+		t.Errorf("Expected the value to be rebuilt once per applied config, got %d builds", builds)
+	}
+	if fixed.Get() != "fixed" {
+		t.Errorf("Expected a fixed value not to follow the config, got %v", fixed.Get())
+	}
+	var none *Derived
+	if none.Get() != nil {
+		t.Errorf("Expected nil for a nil value")
+	}
+}
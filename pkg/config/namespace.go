@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NamespaceMatcher matches namespace names against a list of patterns.
+// A pattern is either an exact name, a glob (e.g. "ci-*")
+// or a regular expression enclosed in slashes (e.g. "/^preview-pr-[0-9]+$/").
+type NamespaceMatcher struct {
+	names   map[string]bool
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+// NewNamespaceMatcher returns a matcher for the given patterns.
+// Error is returned if any of the patterns is not valid.
+func NewNamespaceMatcher(patterns []string) (*NamespaceMatcher, error) {
+	m := &NamespaceMatcher{
+		names: map[string]bool{},
+	}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		switch {
+		case len(p) == 0:
+			continue
+		case len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/"):
+			// anchoring to match the whole name like the globs do
+			re, err := regexp.Compile("^(?:" + p[1:len(p)-1] + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid namespace regexp %q: %v", p, err)
+			}
+			m.regexps = append(m.regexps, re)
+		case strings.ContainsAny(p, "*?["):
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid namespace glob %q: %v", p, err)
+			}
+			m.globs = append(m.globs, p)
+		default:
+			m.names[p] = true
+		}
+	}
+	return m, nil
+}
+
+// Matches returns true if the given namespace matches any of the patterns
+func (m *NamespaceMatcher) Matches(namespace string) bool {
+	if m == nil {
+		return false
+	}
+	// cheapest first
+	if m.names[namespace] {
+		return true
+	}
+	for _, g := range m.globs {
+		if ok, _ := path.Match(g, namespace); ok {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty returns true if the matcher doesn't have any pattern
+func (m *NamespaceMatcher) Empty() bool {
+	return m == nil || len(m.names)+len(m.globs)+len(m.regexps) == 0
+}
+
+// ParseLabelSelector parses a namespace label selector in the Kubernetes syntax,
+// a requirement in the !key=value form is read as key!=value
+func ParseLabelSelector(selector string) (labels.Selector, error) {
+	// splitting the requirements, the values of the set based ones are separated by commas too
+	requirements := []string{}
+	depth, start := 0, 0
+	for i, r := range selector {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				requirements = append(requirements, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	requirements = append(requirements, selector[start:])
+
+	for i, req := range requirements {
+		req = strings.TrimSpace(req)
+		if !strings.HasPrefix(req, "!") || strings.Contains(req, "!=") {
+			// e.g. !key (key doesn't exist) or key!=value
+			continue
+		}
+		if eq := strings.Index(req, "="); eq > 0 {
+			key, value := req[1:eq], strings.TrimPrefix(req[eq+1:], "=")
+			requirements[i] = strings.TrimSpace(key) + "!=" + strings.TrimSpace(value)
+		}
+	}
+	return labels.Parse(strings.Join(requirements, ","))
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// BackupRegistry is an additional registry the images are backed up to, besides the backup registry from the flags
+type BackupRegistry struct {
+	Registry     string `json:"registry"`
+	Organization string `json:"organization"`
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	// CredentialsDir is a directory with the username and password files, takes precedence over Username and Password
+	CredentialsDir string `json:"credentialsDir,omitempty"`
+	// Priority of the registry when choosing the image of a workload, the lowest first.
+	// The backup registry from the flags has priority 0.
+	Priority int `json:"priority"`
+}
+
+// validate checks the registry and reads its credentials from its directory if any
+func (r *BackupRegistry) validate() error {
+	if len(strings.TrimSpace(r.Registry)) == 0 {
+		return errors.New("no registry provided")
+	}
+	if len(strings.TrimSpace(r.Organization)) == 0 {
+		return fmt.Errorf("no organization provided for %s", r.Registry)
+	}
+	if len(strings.TrimSpace(r.CredentialsDir)) != 0 {
+		username, password, err := readCredentials(r.CredentialsDir)
+		if err != nil {
+			return fmt.Errorf("failed to read the credentials of %s: %v", r.Registry, err)
+		}
+		r.Username, r.Password = username, password
+	}
+	if len(strings.TrimSpace(r.Username)) == 0 || len(strings.TrimSpace(r.Password)) == 0 {
+		return fmt.Errorf("no credentials provided for %s", r.Registry)
+	}
+	return nil
+}
+
+// loadBackupRegistries reads the list of the additional backup registries from the given YAML file
+func loadBackupRegistries(file string) ([]BackupRegistry, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	registries := []BackupRegistry{}
+	if err := yaml.Unmarshal(data, &registries); err != nil {
+		return nil, err
+	}
+	return registries, nil
+}
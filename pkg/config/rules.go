@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+
+	"image-clone-controller/pkg/image"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ImageAction is what has to be done with a container image
+type ImageAction string
+
+const (
+	// ImageActionBackup means that the image has to be backed up
+	ImageActionBackup ImageAction = "backup"
+	// ImageActionSkip means that the image has to be left as is
+	ImageActionSkip ImageAction = "skip"
+	// ImageActionWarn means that the image has to be left as is and a warning has to be emitted
+	ImageActionWarn ImageAction = "warn"
+)
+
+// ImageRule decides what to do with the images matching all its non empty fields
+type ImageRule struct {
+	// Registry is a glob matched against the image registry (docker.io if not specified in the image)
+	Registry string `json:"registry,omitempty"`
+	// Repository is a glob matched against the image repository (e.g. library/nginx)
+	Repository string `json:"repository,omitempty"`
+	// Tag is a regexp matched against the image tag
+	Tag string `json:"tag,omitempty"`
+	// Action to take for the matched images
+	Action ImageAction `json:"action"`
+}
+
+// ImageRuleSet is an ordered set of image rules, the first matching rule wins
+type ImageRuleSet struct {
+	rules []compiledImageRule
+}
+
+type compiledImageRule struct {
+	ImageRule
+	tag *regexp.Regexp
+}
+
+// NewImageRuleSet returns a rule set for the given rules.
+// Error is returned if any of the rules is not valid.
+func NewImageRuleSet(rules []ImageRule) (*ImageRuleSet, error) {
+	set := &ImageRuleSet{}
+	for i, r := range rules {
+		switch r.Action {
+		case ImageActionBackup, ImageActionSkip, ImageActionWarn:
+		default:
+			return nil, fmt.Errorf("image rule %d: unknown action %q", i, r.Action)
+		}
+		if r.Registry == "" && r.Repository == "" && r.Tag == "" {
+			return nil, fmt.Errorf("image rule %d: at least one of registry, repository or tag is expected", i)
+		}
+		if _, err := path.Match(r.Registry, ""); err != nil {
+			return nil, fmt.Errorf("image rule %d: invalid registry glob %q: %v", i, r.Registry, err)
+		}
+		if _, err := path.Match(r.Repository, ""); err != nil {
+			return nil, fmt.Errorf("image rule %d: invalid repository glob %q: %v", i, r.Repository, err)
+		}
+		compiled := compiledImageRule{ImageRule: r}
+		if r.Tag != "" {
+			re, err := regexp.Compile(r.Tag)
+			if err != nil {
+				return nil, fmt.Errorf("image rule %d: invalid tag regexp %q: %v", i, r.Tag, err)
+			}
+			compiled.tag = re
+		}
+		set.rules = append(set.rules, compiled)
+	}
+	return set, nil
+}
+
+// Decide returns the action for the given image and the rule which matched it.
+// Images not matching any rule are backed up, nil rule is returned for them.
+func (s *ImageRuleSet) Decide(fullName string) (ImageAction, *ImageRule) {
+	if s == nil {
+		return ImageActionBackup, nil
+	}
+	ref := image.Parse(fullName)
+	for i := range s.rules {
+		if s.rules[i].matches(ref) {
+			return s.rules[i].Action, &s.rules[i].ImageRule
+		}
+	}
+	return ImageActionBackup, nil
+}
+
+// matches returns true if all the non empty fields of the rule match the reference
+func (r *compiledImageRule) matches(ref image.Reference) bool {
+	if r.Registry != "" {
+		if ok, _ := path.Match(image.NormalizeRegistry(r.Registry), ref.Registry); !ok {
+			return false
+		}
+	}
+	if r.Repository != "" {
+		if ok, _ := path.Match(r.Repository, ref.Repository); !ok {
+			return false
+		}
+	}
+	if r.tag != nil && !r.tag.MatchString(ref.Tag) {
+		return false
+	}
+	return true
+}
+
+// loadImageRules reads the list of image rules from the given YAML file
+func loadImageRules(file string) ([]ImageRule, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rules := []ImageRule{}
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewImageRuleSet(t *testing.T) {
+	testCases := []struct {
+		name          string
+		rules         []ImageRule
+		expectedError bool
+	}{
+		{
+			name: "Valid",
+			rules: []ImageRule{
+				{Registry: "docker.io", Repository: "nvidia/*", Tag: "^latest$", Action: ImageActionSkip},
+				{Registry: "*.internal", Action: ImageActionWarn},
+				{Repository: "library/*", Action: ImageActionBackup},
+			},
+		},
+		{
+			name:          "Unknown action",
+			rules:         []ImageRule{{Registry: "docker.io", Action: "delete"}},
+			expectedError: true,
+		},
+		{
+			name:          "Empty rule",
+			rules:         []ImageRule{{Action: ImageActionSkip}},
+			expectedError: true,
+		},
+		{
+			name:          "Invalid glob",
+			rules:         []ImageRule{{Repository: "nvidia/[", Action: ImageActionSkip}},
+			expectedError: true,
+		},
+		{
+			name:          "Invalid regexp",
+			rules:         []ImageRule{{Tag: "(latest", Action: ImageActionSkip}},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewImageRuleSet(tc.rules)
+			if err != nil {
+				if !tc.expectedError {
+					t.Errorf("Got not expected error: %v", err)
+				}
+			} else {
+				if tc.expectedError {
+					t.Error("Got no error while one is expected")
+				}
+			}
+		})
+	}
+}
+
+func TestImageRuleSetDecide(t *testing.T) {
+	set, err := NewImageRuleSet([]ImageRule{
+		{Registry: "registry-1.docker.io", Repository: "nvidia/*", Action: ImageActionSkip},
+		{Registry: "*.corp.internal", Action: ImageActionSkip},
+		{Repository: "licensed/*", Tag: "^enterprise-", Action: ImageActionWarn},
+		{Repository: "library/*", Tag: "^latest$", Action: ImageActionBackup},
+		{Repository: "library/*", Action: ImageActionWarn},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the rule set: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected ImageAction
+	}{
+		{
+			name:     "Registry alias and repository glob",
+			input:    "nvidia/cuda:11.0-base",
+			expected: ImageActionSkip,
+		},
+		{
+			name:     "Registry glob",
+			input:    "registry.corp.internal/team/app:1.0",
+			expected: ImageActionSkip,
+		},
+		{
+			name:     "Tag regexp",
+			input:    "quay.io/licensed/product:enterprise-1.2",
+			expected: ImageActionWarn,
+		},
+		{
+			name:     "Tag regexp not matching",
+			input:    "quay.io/licensed/product:community-1.2",
+			expected: ImageActionBackup,
+		},
+		{
+			name:     "First rule wins",
+			input:    "nginx",
+			expected: ImageActionBackup,
+		},
+		{
+			name:     "Official image",
+			input:    "nginx:1.19",
+			expected: ImageActionWarn,
+		},
+		{
+			name:     "No rule",
+			input:    "quay.io/coredns/coredns:1.3.1",
+			expected: ImageActionBackup,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, _ := set.Decide(tc.input)
+			if output != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestLoadImageRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "rules.yaml")
+	content := `
+- registry: docker.io
+  repository: nvidia/*
+  action: skip
+- tag: ^enterprise-
+  action: warn
+`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write the rules: %v", err)
+	}
+
+	expected := []ImageRule{
+		{Registry: "docker.io", Repository: "nvidia/*", Action: ImageActionSkip},
+		{Tag: "^enterprise-", Action: ImageActionWarn},
+	}
+	output, err := loadImageRules(file)
+	if err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, output)
+	}
+}
@@ -3,12 +3,16 @@ package controller
 import (
 	"image-clone-controller/pkg/controller/daemonset"
 	"image-clone-controller/pkg/controller/deployment"
+	"image-clone-controller/pkg/controller/imagebackup"
+	"image-clone-controller/pkg/controller/imageclonepolicy"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 func init() {
 	AddToManagerFuncs = append(AddToManagerFuncs, daemonset.Add)
 	AddToManagerFuncs = append(AddToManagerFuncs, deployment.Add)
+	AddToManagerFuncs = append(AddToManagerFuncs, imagebackup.Add)
+	AddToManagerFuncs = append(AddToManagerFuncs, imageclonepolicy.Add)
 }
 
 // AddToManagerFuncs is a list of functions to add all controllers to the manager
@@ -3,11 +3,15 @@ package deployment
 import (
 	"context"
 
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
 	"image-clone-controller/pkg/controller/utils"
-	"image-clone-controller/pkg/registry"
+	"image-clone-controller/pkg/controller/workload"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -17,7 +21,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-var log = logf.Log.WithName("deployment-controller")
+const controllerName = "deployment-controller"
+
+var log = logf.Log.WithName(controllerName)
 
 // Add creates a new deployment controller and adds it to the manager
 func Add(mgr manager.Manager) error {
@@ -27,20 +33,59 @@ func Add(mgr manager.Manager) error {
 // newReconciler returns a new deployment reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
 	return &ReconcileDeployment{
-		client:    mgr.GetClient(),
-		regClient: registry.NewClientFromConfig(),
+		client:   mgr.GetClient(),
+		migrator: workload.NewMigratorFromConfig(mgr, controllerName),
 	}
 }
 
 // add adds a new controller to the given manager
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
-	c, err := controller.New("deployment-controller", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New(controllerName, mgr, controller.Options{Reconciler: r})
 	if err != nil {
 		return err
 	}
 
 	pred := utils.NewBlacklistNamespacePredicateFromConfig()
-	if err = c.Watch(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestForObject{}, pred); err != nil {
+	selPred := utils.NewNamespaceSelectorPredicateFromConfig(mgr.GetClient())
+	if err = c.Watch(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestForObject{}, pred, selPred); err != nil {
+		return err
+	}
+
+	// namespaces entering the selection: reconcile all their deployments
+	nsHandler := utils.NewNamespaceWorkloadsHandler(mgr.GetClient(), func() runtime.Object { return &appsv1.DeploymentList{} })
+	if err = c.Watch(&source.Kind{Type: &corev1.Namespace{}}, nsHandler, utils.NewNamespaceSelectionPredicateFromConfig()); err != nil {
+		return err
+	}
+
+	// backup destination of the namespace changed: reconcile all its deployments
+	destHandler := utils.NewObjectNamespaceWorkloadsHandler(mgr.GetClient(), func() runtime.Object { return &appsv1.DeploymentList{} })
+	if err = c.Watch(&source.Kind{Type: &v1alpha1.BackupDestination{}}, destHandler, pred, selPred); err != nil {
+		return err
+	}
+
+	// pods failing to pull their images: verify the rollout or fail over deployments
+	if config.GlobalConfig.PullFailuresWatched() {
+		podHandler := workload.NewPodPullFailureHandler(mgr.GetClient(), workload.KindDeployment)
+		if err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, podHandler, pred, selPred); err != nil {
+			return err
+		}
+	}
+
+	// images backed up: reconcile the deployments waiting for them
+	watched := func(ns string) bool { return pred.Watched(ns) && selPred.Selected(ns) }
+	if err = c.Watch(&source.Kind{Type: &v1alpha1.ImageBackup{}}, workload.NewImageBackupHandler(workload.KindDeployment, watched)); err != nil {
+		return err
+	}
+
+	// credentials of the backup destination of a namespace changed: update the pull secret of all its deployments
+	credSource := utils.NewCredentialsRotationWorkloadsSource(mgr.GetClient(), mgr.GetAPIReader(), func() runtime.Object { return &appsv1.DeploymentList{} }, watched)
+	if err = c.Watch(credSource, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+
+	// configuration changed: reconcile all the deployments from the watched namespaces
+	cfgSource := utils.NewConfigChangeWorkloadsSource(mgr.GetClient(), func() runtime.Object { return &appsv1.DeploymentList{} }, watched)
+	if err = c.Watch(cfgSource, &handler.EnqueueRequestForObject{}); err != nil {
 		return err
 	}
 
@@ -52,8 +97,8 @@ var _ reconcile.Reconciler = &ReconcileDeployment{}
 // ReconcileDeployment reconciles a Deployment object
 type ReconcileDeployment struct {
 	// split client (reads from the cache, writes to API)
-	client    client.Client
-	regClient *registry.Client
+	client   client.Client
+	migrator *workload.Migrator
 }
 
 // Reconcile migrates Deployments to backed up images
@@ -67,36 +112,12 @@ func (r *ReconcileDeployment) Reconcile(request reconcile.Request) (reconcile.Re
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// object was deleted - nothing to do
+			r.migrator.Forget(workload.KindDeployment, request.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		// error getting the deployment - requeue the request
 		return reconcile.Result{}, err
 	}
 
-	// checking the images
-	numChangedImg, numErrorImg := 0, 0
-	for i, c := range instance.Spec.Template.Spec.Containers {
-		if r.regClient.Belongs(c.Image) {
-			continue
-		}
-		logger.Info("Cloning the image", "Image", c.Image)
-		if newImg, err := r.regClient.Backup(c.Image); err == nil {
-			instance.Spec.Template.Spec.Containers[i].Image = newImg
-			numChangedImg++
-		} else {
-			logger.Error(err, "Failed to clone the image", "Image", c.Image)
-			numErrorImg++
-			// best effort: backup as many as possible, no requeue
-		}
-	}
-
-	// migrating to the new images
-	if numChangedImg > 0 {
-		logger.Info("Updating the deployment to backed up images", "Changed images", numChangedImg)
-		r.client.Update(context.Background(), instance)
-	} else if numErrorImg == 0 {
-		logger.Info("Deployment is fully backed up!")
-	}
-
-	return reconcile.Result{}, nil
+	return r.migrator.Migrate(logger, workload.New(workload.KindDeployment, instance, &instance.Spec.Template))
 }
@@ -0,0 +1,407 @@
+package imagebackup
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/controller/utils"
+	"image-clone-controller/pkg/controller/workload"
+	"image-clone-controller/pkg/registry"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	controllerName = "imagebackup-controller"
+	// destinationField indexes the ImageBackups by their backup images, one per backup registry
+	destinationField = "status.replicas"
+	// namespaceField indexes the ImageBackups by the namespace of their BackupDestination
+	namespaceField = "spec.namespace"
+	// copy retry backoff
+	minRetryDelay = 30 * time.Second
+	maxRetryDelay = time.Hour
+)
+
+var log = logf.Log.WithName(controllerName)
+
+// Add creates a new imagebackup controller and adds it to the manager
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new imagebackup reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	pred := utils.NewBlacklistNamespacePredicateFromConfig()
+	selPred := utils.NewNamespaceSelectorPredicateFromConfig(mgr.GetClient())
+	return &ReconcileImageBackup{
+		client:    mgr.GetClient(),
+		apiReader: mgr.GetAPIReader(),
+		regClient: registry.NewClientFromConfig(),
+		watched:   func(ns string) bool { return pred.Watched(ns) && selPred.Selected(ns) },
+	}
+}
+
+// add adds a new controller to the given manager
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	c, err := controller.New(controllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// status updates are done by this controller, no need to react on them
+	if err = c.Watch(&source.Kind{Type: &v1alpha1.ImageBackup{}}, &handler.EnqueueRequestForObject{}, predicate.GenerationChangedPredicate{}); err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(&v1alpha1.ImageBackup{}, destinationField, func(o runtime.Object) []string {
+		return o.(*v1alpha1.ImageBackup).ReplicaImages()
+	})
+	if err != nil {
+		return err
+	}
+
+	// the referencing workloads are looked up by image
+	if err = workload.IndexImages(mgr.GetFieldIndexer()); err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(&v1alpha1.ImageBackup{}, namespaceField, func(o runtime.Object) []string {
+		if ns := o.(*v1alpha1.ImageBackup).Spec.Namespace; ns != "" {
+			return []string{ns}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// backup destinations changing: the backup images may have changed
+	destHandler := &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			return namespaceImageBackups(mgr.GetClient(), o.Meta.GetNamespace())
+		}),
+	}
+	if err = c.Watch(&source.Kind{Type: &v1alpha1.BackupDestination{}}, destHandler); err != nil {
+		return err
+	}
+
+	// workloads changing their images: update the list of the referencing workloads
+	workloadHandler := &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			return workloadImageBackups(mgr.GetClient(), o.Object)
+		}),
+	}
+	if err = c.Watch(&source.Kind{Type: &appsv1.Deployment{}}, workloadHandler); err != nil {
+		return err
+	}
+	if err = c.Watch(&source.Kind{Type: &appsv1.DaemonSet{}}, workloadHandler); err != nil {
+		return err
+	}
+
+	// configuration changed: the backup destination may have changed
+	cfgSource := &utils.ConfigChangeSource{Requests: func() []reconcile.Request {
+		return allImageBackups(mgr.GetClient())
+	}}
+	if err = c.Watch(cfgSource, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileImageBackup{}
+
+// ReconcileImageBackup reconciles an ImageBackup object
+type ReconcileImageBackup struct {
+	// split client (reads from the cache, writes to API)
+	client client.Client
+	// reads the Secrets, which are not cached, from the API
+	apiReader client.Reader
+	regClient *registry.Client
+	// returns true if the workloads of the namespace are watched, like the workload controllers do
+	watched func(namespace string) bool
+}
+
+// Reconcile copies the source image to the backup registry
+// and keeps the list of the workloads using the image up to date
+func (r *ReconcileImageBackup) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	logger := log.WithValues("imagebackup", request.Name)
+	logger.Info("Reconciling imagebackup")
+
+	// fetch imagebackup instance
+	instance := &v1alpha1.ImageBackup{}
+	err := r.client.Get(context.Background(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// object was deleted - nothing to do
+			return reconcile.Result{}, nil
+		}
+		// error getting the imagebackup - requeue the request
+		return reconcile.Result{}, err
+	}
+	oldStatus := instance.Status.DeepCopy()
+
+	refs, err := r.referencingWorkloads(instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	instance.Status.Workloads = refs
+	// the unused backups are garbage collected after a grace period
+	if len(refs) != 0 {
+		instance.Status.UnreferencedSince = nil
+	} else if instance.Status.UnreferencedSince == nil {
+		unreferencedSince := metav1.Now()
+		instance.Status.UnreferencedSince = &unreferencedSince
+	}
+
+	regClient, err := r.registryClient(instance)
+	if err != nil {
+		logger.Error(err, "Failed to get the backup destination")
+		instance.Status.LastError = err.Error()
+		if !reflect.DeepEqual(oldStatus, &instance.Status) {
+			if err := r.client.Status().Update(context.Background(), instance); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		// the credentials Secret is not watched
+		return reconcile.Result{RequeueAfter: minRetryDelay}, nil
+	}
+
+	if instance.BackedUp() && replicated(instance, regClient) {
+		if cfg := config.Current(); cfg.TagResyncInterval > 0 && instance.MutableTag() {
+			return r.resync(logger, instance, oldStatus, regClient, cfg)
+		}
+		if !reflect.DeepEqual(oldStatus, &instance.Status) {
+			return reconcile.Result{}, r.client.Status().Update(context.Background(), instance)
+		}
+		return reconcile.Result{}, nil
+	}
+
+	// workload events shouldn't bypass the retry backoff
+	if last := instance.Status.LastAttemptTime; instance.Status.LastError != "" && last != nil {
+		if wait := retryDelay(instance.Status.CopyAttempts) - time.Since(last.Time); wait > 0 {
+			if !reflect.DeepEqual(oldStatus, &instance.Status) {
+				if err := r.client.Status().Update(context.Background(), instance); err != nil {
+					return reconcile.Result{}, err
+				}
+			}
+			return reconcile.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	// recording the attempt before the copy which may take a while
+	attemptTime := metav1.Now()
+	instance.Status.LastAttemptTime = &attemptTime
+	instance.Status.CopyAttempts++
+	if err := r.client.Status().Update(context.Background(), instance); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// the source digest is the reference of the tag re-syncs
+	sourceDigest := ""
+	if config.Current().TagResyncInterval > 0 && instance.MutableTag() {
+		if upstream, err := regClient.InspectSource(instance.Spec.Source); err == nil {
+			sourceDigest = upstream.Digest
+		}
+	}
+
+	logger.Info("Cloning the image", "Image", instance.Spec.Source, "Attempt", instance.Status.CopyAttempts)
+	info, err := regClient.Backup(instance.Spec.Source)
+	if info == nil {
+		logger.Error(err, "Failed to clone the image", "Image", instance.Spec.Source)
+		instance.Status.LastError = err.Error()
+		if err := r.client.Status().Update(context.Background(), instance); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{RequeueAfter: retryDelay(instance.Status.CopyAttempts)}, nil
+	}
+
+	now := metav1.Now()
+	instance.Status.Source = instance.Spec.Source
+	instance.Status.Destination = info.Name
+	instance.Status.Replicas = info.Replicas
+	instance.Status.Digest = info.Digest
+	instance.Status.Size = info.Size
+	instance.Status.SourceDigest = sourceDigest
+	instance.Status.LastSyncTime = &now
+	instance.Status.LastError = ""
+	result := reconcile.Result{}
+	if err != nil {
+		// the copies to the other registries are retried with the backoff
+		logger.Error(err, "Failed to clone the image to some of the backup registries", "Image", instance.Spec.Source)
+		instance.Status.LastError = err.Error()
+		result.RequeueAfter = retryDelay(instance.Status.CopyAttempts)
+	}
+	logger.Info("Image is backed up!", "Destination", info.Name, "Digest", info.Digest, "Replicas", info.Replicas)
+	return result, r.client.Status().Update(context.Background(), instance)
+}
+
+// replicated returns true if the ImageBackup has a copy in each backup registry of the given client
+func replicated(backup *v1alpha1.ImageBackup, regClient *registry.Client) bool {
+	copied := map[string]bool{}
+	for _, name := range backup.ReplicaImages() {
+		copied[name] = true
+	}
+	for _, name := range regClient.Destinations(backup.Spec.Source) {
+		if !copied[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// registryClient returns the registry client for the backup destination of the ImageBackup
+func (r *ReconcileImageBackup) registryClient(backup *v1alpha1.ImageBackup) (*registry.Client, error) {
+	if backup.Spec.Namespace == "" {
+		return r.regClient, nil
+	}
+	regClient, err := workload.NamespaceRegistryClient(r.client, r.apiReader, backup.Spec.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if regClient == nil {
+		return nil, fmt.Errorf("no backup destination in namespace %s", backup.Spec.Namespace)
+	}
+	return regClient, nil
+}
+
+// referencingWorkloads returns the sorted list of the workloads using either the source image or one of the backup images,
+// a workload may run the copy in any of the backup registries.
+// Only the workloads backed up to the same destination as the ImageBackup are taken into account,
+// and for the source image only the workloads of the watched namespaces: the others are never migrated.
+func (r *ReconcileImageBackup) referencingWorkloads(backup *v1alpha1.ImageBackup) ([]v1alpha1.WorkloadReference, error) {
+	replicas := backup.ReplicaImages()
+	workloads, err := workload.ListUsing(r.client, backup.Spec.Namespace, append([]string{backup.Spec.Source}, replicas...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// namespaces having their own destination
+	dests := &v1alpha1.BackupDestinationList{}
+	if err := r.client.List(context.Background(), dests); err != nil {
+		return nil, err
+	}
+	ownDestination := map[string]bool{}
+	for _, d := range dests.Items {
+		if d.Name == v1alpha1.DefaultBackupDestinationName {
+			ownDestination[d.Namespace] = true
+		}
+	}
+
+	refs := []v1alpha1.WorkloadReference{}
+	for _, w := range workloads {
+		if backup.Spec.Namespace == "" && ownDestination[w.Object.GetNamespace()] {
+			continue
+		}
+		// a workload running a backup image keeps it whatever its namespace, e.g. blacklisted after the migration
+		if usesAny(w, replicas) || r.watched(w.Object.GetNamespace()) {
+			refs = append(refs, w.Reference())
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Kind != refs[j].Kind {
+			return refs[i].Kind < refs[j].Kind
+		}
+		if refs[i].Namespace != refs[j].Namespace {
+			return refs[i].Namespace < refs[j].Namespace
+		}
+		return refs[i].Name < refs[j].Name
+	})
+	return refs, nil
+}
+
+// usesAny returns true if the workload uses any of the given images
+func usesAny(w *workload.Workload, images []string) bool {
+	for _, image := range images {
+		if w.Uses(image) {
+			return true
+		}
+	}
+	return false
+}
+
+// workloadImageBackups returns the reconcile requests for the ImageBackups of the workload's images
+func workloadImageBackups(c client.Reader, obj runtime.Object) []reconcile.Request {
+	w := workload.FromObject(obj)
+	if w == nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, image := range w.Images() {
+		// the image may be a source, backed up globally or to the namespace's destination
+		requests = append(requests,
+			reconcile.Request{NamespacedName: types.NamespacedName{Name: v1alpha1.ImageBackupName(image)}},
+			reconcile.Request{NamespacedName: types.NamespacedName{Name: v1alpha1.NamespaceImageBackupName(w.Object.GetNamespace(), image)}},
+		)
+
+		// or a backup
+		backups := &v1alpha1.ImageBackupList{}
+		if err := c.List(context.Background(), backups, client.MatchingField(destinationField, image)); err != nil {
+			log.Error(err, "Failed to list the image backups", "Image", image)
+			continue
+		}
+		for _, b := range backups.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: b.Name}})
+		}
+	}
+	return requests
+}
+
+// allImageBackups returns the reconcile requests for all the ImageBackups
+func allImageBackups(c client.Reader) []reconcile.Request {
+	backups := &v1alpha1.ImageBackupList{}
+	if err := c.List(context.Background(), backups); err != nil {
+		log.Error(err, "Failed to list the image backups")
+		return nil
+	}
+	return backupRequests(backups)
+}
+
+// namespaceImageBackups returns the reconcile requests for the ImageBackups to the BackupDestination of the given namespace
+func namespaceImageBackups(c client.Reader, namespace string) []reconcile.Request {
+	backups := &v1alpha1.ImageBackupList{}
+	if err := c.List(context.Background(), backups, client.MatchingField(namespaceField, namespace)); err != nil {
+		log.Error(err, "Failed to list the image backups", "Namespace", namespace)
+		return nil
+	}
+	return backupRequests(backups)
+}
+
+// backupRequests returns the reconcile requests for the given ImageBackups
+func backupRequests(backups *v1alpha1.ImageBackupList) []reconcile.Request {
+	requests := []reconcile.Request{}
+	for _, b := range backups.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: b.Name}})
+	}
+	return requests
+}
+
+// retryDelay returns the delay before the next copy attempt: exponential backoff capped at maxRetryDelay
+func retryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,112 @@
+package imagebackup
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/registry"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileReferences(t *testing.T) {
+	syncTime := metav1.Now()
+	backup := &v1alpha1.ImageBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.ImageBackupName("nginx:1.19")},
+		Spec:       v1alpha1.ImageBackupSpec{Source: "nginx:1.19"},
+		Status: v1alpha1.ImageBackupStatus{
+			Source:       "nginx:1.19",
+			Destination:  "quay.io/org/nginx:1.19",
+			Replicas:     []string{"quay.io/org/nginx:1.19", "dr.example.com/org/nginx:1.19"},
+			Digest:       "sha256:a",
+			LastSyncTime: &syncTime,
+		},
+	}
+	deployment := func(ns, name, image string) *appsv1.Deployment {
+		d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name}}
+		d.Spec.Template.Spec.Containers = []corev1.Container{{Name: "a", Image: image}}
+		return d
+	}
+
+	testCases := []struct {
+		name               string
+		workloads          []runtime.Object
+		expectedWorkloads  []string
+		expectedReferenced bool
+	}{
+		{
+			name:               "Workload on the secondary registry",
+			workloads:          []runtime.Object{deployment("ns", "app", "dr.example.com/org/nginx:1.19")},
+			expectedWorkloads:  []string{"ns/app"},
+			expectedReferenced: true,
+		},
+		{
+			name:               "Workload on the secondary registry of an ignored namespace",
+			workloads:          []runtime.Object{deployment("ignored", "app", "dr.example.com/org/nginx:1.19")},
+			expectedWorkloads:  []string{"ignored/app"},
+			expectedReferenced: true,
+		},
+		{
+			name: "Source image",
+			workloads: []runtime.Object{
+				deployment("ns", "app", "nginx:1.19"),
+				deployment("ignored", "app", "nginx:1.19"),
+				deployment("ns", "other", "dr.example.com/org/nginx:1.18"),
+			},
+			expectedWorkloads:  []string{"ns/app"},
+			expectedReferenced: true,
+		},
+		{
+			name:               "Unreferenced",
+			workloads:          []runtime.Object{deployment("ignored", "app", "nginx:1.19")},
+			expectedWorkloads:  []string{},
+			expectedReferenced: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			if err := scheme.AddToScheme(s); err != nil {
+				t.Fatalf("Failed to build the scheme: %v", err)
+			}
+			if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+				t.Fatalf("Failed to build the scheme: %v", err)
+			}
+			cli := fake.NewFakeClientWithScheme(s, append(tc.workloads, backup.DeepCopy())...)
+			r := &ReconcileImageBackup{
+				client:    cli,
+				apiReader: cli,
+				regClient: registry.NewClient("quay.io", "org", "", "", 0),
+				watched:   func(ns string) bool { return ns != "ignored" },
+			}
+
+			if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: backup.Name}}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			output := &v1alpha1.ImageBackup{}
+			if err := cli.Get(context.Background(), types.NamespacedName{Name: backup.Name}, output); err != nil {
+				t.Fatalf("Failed to get the image backup: %v", err)
+			}
+			workloads := []string{}
+			for _, ref := range output.Status.Workloads {
+				workloads = append(workloads, ref.Namespace+"/"+ref.Name)
+			}
+			if !reflect.DeepEqual(workloads, tc.expectedWorkloads) {
+				t.Errorf("Expected workloads %v, got %v", tc.expectedWorkloads, workloads)
+			}
+			if referenced := output.Status.UnreferencedSince == nil; referenced != tc.expectedReferenced {
+				t.Errorf("Expected referenced: %v, got unreferenced since %v", tc.expectedReferenced, output.Status.UnreferencedSince)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package imagebackup
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/controller/workload"
+	"image-clone-controller/pkg/metrics"
+	"image-clone-controller/pkg/registry"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// resync compares the source image of a backed up mutable tag with the backup once per interval
+// and copies it again if it changed upstream. The workloads using the backup are restarted if configured so.
+func (r *ReconcileImageBackup) resync(logger logr.Logger, instance *v1alpha1.ImageBackup, oldStatus *v1alpha1.ImageBackupStatus,
+	regClient *registry.Client, cfg *config.Config) (reconcile.Result, error) {
+	if last := instance.Status.LastCheckTime; last != nil {
+		if wait := cfg.TagResyncInterval - time.Since(last.Time); wait > 0 {
+			if !reflect.DeepEqual(oldStatus, &instance.Status) {
+				if err := r.client.Status().Update(context.Background(), instance); err != nil {
+					return reconcile.Result{}, err
+				}
+			}
+			return reconcile.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	checkTime := metav1.Now()
+	instance.Status.LastCheckTime = &checkTime
+	result := reconcile.Result{RequeueAfter: cfg.TagResyncInterval}
+
+	upstream, err := regClient.InspectSource(instance.Spec.Source)
+	if err != nil {
+		logger.Error(err, "Failed to inspect the upstream image", "Image", instance.Spec.Source)
+		metrics.TagResynced(metrics.ResultFailure)
+		instance.Status.LastError = fmt.Sprintf("tag re-sync: %v", err)
+		return result, r.client.Status().Update(context.Background(), instance)
+	}
+	// the backups of the manifest lists have the digest of a single platform image
+	synced := instance.Status.SourceDigest
+	if synced == "" {
+		synced = instance.Status.Digest
+	}
+	if upstream.Digest == synced {
+		metrics.TagResynced(metrics.ResultUnchanged)
+		instance.Status.SourceDigest = upstream.Digest
+		instance.Status.LastError = ""
+		return result, r.client.Status().Update(context.Background(), instance)
+	}
+
+	logger.Info("Upstream image changed, copying it again", "Image", instance.Spec.Source, "Digest", upstream.Digest)
+	info, err := regClient.Backup(instance.Spec.Source)
+	if info == nil {
+		logger.Error(err, "Failed to clone the image", "Image", instance.Spec.Source)
+		metrics.TagResynced(metrics.ResultFailure)
+		instance.Status.LastError = fmt.Sprintf("tag re-sync: %v", err)
+		return result, r.client.Status().Update(context.Background(), instance)
+	}
+	metrics.TagResynced(metrics.ResultUpdated)
+	syncTime := metav1.Now()
+	// the previous manifest remains in the registries untagged until the garbage collection
+	if d := instance.Status.Digest; d != "" && d != info.Digest && !contains(instance.Status.SupersededDigests, d) {
+		instance.Status.SupersededDigests = append(instance.Status.SupersededDigests, d)
+	}
+	instance.Status.Destination = info.Name
+	instance.Status.Replicas = info.Replicas
+	instance.Status.Digest = info.Digest
+	instance.Status.Size = info.Size
+	instance.Status.SourceDigest = upstream.Digest
+	instance.Status.LastSyncTime = &syncTime
+	instance.Status.LastError = ""
+	if err != nil {
+		// the missing copies are made again by the next reconciliations
+		logger.Error(err, "Failed to clone the image to some of the backup registries", "Image", instance.Spec.Source)
+		instance.Status.LastError = fmt.Sprintf("tag re-sync: %v", err)
+	}
+	if err := r.client.Status().Update(context.Background(), instance); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if cfg.TagResyncPolicy == config.TagResyncRollout {
+		if err := r.restartWorkloads(logger, instance); err != nil {
+			// e.g. the controller is not allowed to patch the workloads
+			instance.Status.LastError = fmt.Sprintf("tag re-sync: %v", err)
+			return result, r.client.Status().Update(context.Background(), instance)
+		}
+	}
+	return result, nil
+}
+
+// restartWorkloads requests the restart of the workloads using the backup image for their pods to run the refreshed image.
+// The workload controllers restart them like they update them: within the maintenance windows and paced.
+// Returns the last error if the restart of some of the workloads couldn't be requested.
+func (r *ReconcileImageBackup) restartWorkloads(logger logr.Logger, instance *v1alpha1.ImageBackup) error {
+	failed := 0
+	var lastErr error
+	for _, ref := range instance.Status.Workloads {
+		w, err := workload.Get(r.client, ref)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			logger.Error(err, "Failed to get the workload", "Workload", ref)
+			failed, lastErr = failed+1, err
+			continue
+		}
+		if !usesAny(w, instance.ReplicaImages()) {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Requesting the restart of the %s to run the refreshed image", ref.Kind), "Workload", w.NamespacedName())
+		if err := w.RequestRestart(r.client, time.Now()); err != nil {
+			logger.Error(err, "Failed to request the restart of the workload", "Workload", ref)
+			failed, lastErr = failed+1, err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to request the restart of %d workload(s): %v", failed, lastErr)
+	}
+	return nil
+}
+
+// contains returns true if the list contains the given string
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
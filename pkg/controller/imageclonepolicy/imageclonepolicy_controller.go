@@ -0,0 +1,130 @@
+package imageclonepolicy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const controllerName = "imageclonepolicy-controller"
+
+var log = logf.Log.WithName(controllerName)
+
+// Add creates a new imageclonepolicy controller and adds it to the manager
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new imageclonepolicy reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileImageClonePolicy{client: mgr.GetClient()}
+}
+
+// add adds a new controller to the given manager
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	c, err := controller.New(controllerName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// status updates are done by this controller, no need to react on them
+	return c.Watch(&source.Kind{Type: &v1alpha1.ImageClonePolicy{}}, &handler.EnqueueRequestForObject{}, predicate.GenerationChangedPredicate{})
+}
+
+var _ reconcile.Reconciler = &ReconcileImageClonePolicy{}
+
+// ReconcileImageClonePolicy reconciles an ImageClonePolicy object
+type ReconcileImageClonePolicy struct {
+	// split client (reads from the cache, writes to API)
+	client client.Client
+}
+
+// Reconcile puts the configuration from the cluster policy in effect,
+// the configuration from the flags is restored once the policy is deleted
+func (r *ReconcileImageClonePolicy) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	logger := log.WithValues("imageclonepolicy", request.Name)
+	logger.Info("Reconciling imageclonepolicy")
+
+	// fetch imageclonepolicy instance
+	instance := &v1alpha1.ImageClonePolicy{}
+	err := r.client.Get(context.Background(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			if request.Name == v1alpha1.ClusterPolicyName {
+				changed, err := apply(config.GlobalConfig.Copy())
+				if changed {
+					logger.Info("Policy deleted, configuration from the flags restored")
+				}
+				return reconcile.Result{}, err
+			}
+			return reconcile.Result{}, nil
+		}
+		// error getting the imageclonepolicy - requeue the request
+		return reconcile.Result{}, err
+	}
+
+	status := v1alpha1.ImageClonePolicyStatus{ObservedGeneration: instance.Generation}
+	if request.Name != v1alpha1.ClusterPolicyName {
+		status.Error = fmt.Sprintf("only the policy named %q is taken into account", v1alpha1.ClusterPolicyName)
+	} else if changed, err := apply(policyConfig(config.GlobalConfig, &instance.Spec)); err != nil {
+		logger.Error(err, "Invalid policy, the configuration in effect is kept")
+		status.Error = err.Error()
+	} else {
+		if changed {
+			logger.Info("New configuration applied")
+		}
+		status.Applied = true
+	}
+
+	if reflect.DeepEqual(status, instance.Status) {
+		return reconcile.Result{}, nil
+	}
+	instance.Status = status
+	return reconcile.Result{}, r.client.Status().Update(context.Background(), instance)
+}
+
+// ApplyClusterPolicy puts the configuration from the cluster policy in effect, if there is a valid one.
+// The policy controller runs on the leader only: called before the manager starts,
+// the other controllers and the replicas which are not the leader don't start with the configuration from the flags.
+func ApplyClusterPolicy(c client.Reader) error {
+	instance := &v1alpha1.ImageClonePolicy{}
+	err := c.Get(context.Background(), types.NamespacedName{Name: v1alpha1.ClusterPolicyName}, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if _, err := apply(policyConfig(config.GlobalConfig, &instance.Spec)); err != nil {
+		// the status is set by the policy controller
+		log.Error(err, "Invalid policy, the configuration from the flags is kept")
+	}
+	return nil
+}
+
+// apply puts the given configuration in effect unless it's the same as the current one (e.g. periodic resync).
+// Returns true if the configuration in effect changed.
+func apply(cfg *config.Config) (bool, error) {
+	// validation completes the configuration, e.g. with the credentials
+	if err := cfg.Validate(); err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(cfg, config.Current()) {
+		return false, nil
+	}
+	return true, config.Apply(cfg)
+}
@@ -0,0 +1,43 @@
+package imageclonepolicy
+
+import (
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+)
+
+// policyConfig returns a copy of the base configuration overridden by the fields set in the policy
+func policyConfig(base *config.Config, spec *v1alpha1.ImageClonePolicySpec) *config.Config {
+	c := base.Copy()
+	if spec.Registry != "" {
+		c.Registry = spec.Registry
+	}
+	if spec.Organization != "" {
+		c.Organization = spec.Organization
+	}
+	if spec.ImageCopyTimeoutSeconds > 0 {
+		c.ImageCopyTimeoutSeconds = spec.ImageCopyTimeoutSeconds
+	}
+	if spec.NamespaceBlacklist != nil {
+		c.AdditionalNamespaceBlacklist = append([]string{}, spec.NamespaceBlacklist...)
+	}
+	if spec.NamespaceWhitelist != nil {
+		c.WhitelistedNamespaces = append([]string{}, spec.NamespaceWhitelist...)
+	}
+	if spec.NamespaceSelector != "" {
+		c.NamespaceSelector = spec.NamespaceSelector
+	}
+	if spec.ImageRules != nil {
+		// the rules of the policy replace the ones from the file
+		c.ImageRulesFile = ""
+		c.ImageRules = []config.ImageRule{}
+		for _, r := range spec.ImageRules {
+			c.ImageRules = append(c.ImageRules, config.ImageRule{
+				Registry:   r.Registry,
+				Repository: r.Repository,
+				Tag:        r.Tag,
+				Action:     config.ImageAction(r.Action),
+			})
+		}
+	}
+	return c
+}
@@ -0,0 +1,71 @@
+package imageclonepolicy
+
+import (
+	"reflect"
+	"testing"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+)
+
+func TestPolicyConfig(t *testing.T) {
+	base := &config.Config{
+		Registry:                     "docker.io",
+		Organization:                 "org",
+		ImageCopyTimeoutSeconds:      60,
+		MandatoryNamespaceBlacklist:  []string{"kube-system"},
+		AdditionalNamespaceBlacklist: []string{"ci-*"},
+		WhitelistedNamespaces:        []string{},
+		ImageRulesFile:               "/etc/rules.yaml",
+		ImageRules:                   []config.ImageRule{{Repository: "nvidia/*", Action: config.ImageActionSkip}},
+	}
+
+	testCases := []struct {
+		name     string
+		spec     v1alpha1.ImageClonePolicySpec
+		expected func(c *config.Config)
+	}{
+		{
+			name:     "Empty policy",
+			expected: func(c *config.Config) {},
+		},
+		{
+			name: "Registry and timeout",
+			spec: v1alpha1.ImageClonePolicySpec{Registry: "quay.io", Organization: "backups", ImageCopyTimeoutSeconds: 30},
+			expected: func(c *config.Config) {
+				c.Registry, c.Organization, c.ImageCopyTimeoutSeconds = "quay.io", "backups", 30
+			},
+		},
+		{
+			name: "Namespaces",
+			spec: v1alpha1.ImageClonePolicySpec{NamespaceBlacklist: []string{}, NamespaceWhitelist: []string{"team-*"}, NamespaceSelector: "a=b"},
+			expected: func(c *config.Config) {
+				c.AdditionalNamespaceBlacklist = []string{}
+				c.WhitelistedNamespaces = []string{"team-*"}
+				c.NamespaceSelector = "a=b"
+			},
+		},
+		{
+			name: "Image rules",
+			spec: v1alpha1.ImageClonePolicySpec{ImageRules: []v1alpha1.ImageRule{{Tag: "^dev-", Action: "warn"}}},
+			expected: func(c *config.Config) {
+				c.ImageRulesFile = ""
+				c.ImageRules = []config.ImageRule{{Tag: "^dev-", Action: config.ImageActionWarn}}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := base.Copy()
+			tc.expected(expected)
+			output := policyConfig(base, &tc.spec)
+			if !reflect.DeepEqual(output, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, output)
+			}
+			if base.Registry != "docker.io" || len(base.AdditionalNamespaceBlacklist) != 1 || base.ImageRulesFile == "" {
+				t.Errorf("Expected the base config not to be modified")
+			}
+		})
+	}
+}
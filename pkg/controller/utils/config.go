@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"image-clone-controller/pkg/config"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ConfigChangeSource is a source which enqueues the requests returned by Requests
+// each time a new configuration of the program is applied
+type ConfigChangeSource struct {
+	Requests func() []reconcile.Request
+	stop     <-chan struct{}
+}
+
+var _ source.Source = &ConfigChangeSource{}
+
+// NewConfigChangeWorkloadsSource returns a source which enqueues all the workloads from the watched namespaces
+// when the configuration changes. newList should return an empty list of the workloads to enqueue.
+func NewConfigChangeWorkloadsSource(c client.Reader, newList func() runtime.Object, watched func(namespace string) bool) *ConfigChangeSource {
+	return &ConfigChangeSource{
+		Requests: func() []reconcile.Request {
+			requests := []reconcile.Request{}
+			for _, r := range namespaceWorkloads(c, "", newList()) {
+				if watched(r.Namespace) {
+					requests = append(requests, r)
+				}
+			}
+			return requests
+		},
+	}
+}
+
+// InjectStopChannel is called by the controller before starting the source
+func (s *ConfigChangeSource) InjectStopChannel(stop <-chan struct{}) error {
+	if s.stop == nil {
+		s.stop = stop
+	}
+	return nil
+}
+
+// Start subscribes to the configuration changes, the handler and the predicates are not used
+func (s *ConfigChangeSource) Start(_ handler.EventHandler, queue workqueue.RateLimitingInterface, _ ...predicate.Predicate) error {
+	changes := config.Subscribe()
+	go func() {
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-changes:
+				requests := s.Requests()
+				log.Info("Configuration changed, reconciling again", "Requests", len(requests))
+				for _, r := range requests {
+					queue.Add(r)
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"time"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// the credentials Secrets of the backup destinations are read again after this delay
+	credentialsPollInterval = time.Minute
+)
+
+// CredentialsRotationSource is a source which enqueues the workloads of the namespaces whose BackupDestination
+// credentials Secret changed, for their pull Secret to be updated. The credentials Secrets are polled from the API:
+// watching them would cache all the Secrets of the cluster.
+type CredentialsRotationSource struct {
+	// reads the BackupDestinations and the workloads from the cache
+	client client.Reader
+	// reads the Secrets from the API
+	apiReader client.Reader
+	newList   func() runtime.Object
+	watched   func(namespace string) bool
+	// fingerprints of the credentials Secrets by namespace
+	fingerprints map[string]string
+	stop         <-chan struct{}
+}
+
+var _ source.Source = &CredentialsRotationSource{}
+
+// NewCredentialsRotationWorkloadsSource returns a source which enqueues the workloads from the watched namespaces
+// whose backup destination credentials changed. newList should return an empty list of the workloads to enqueue.
+func NewCredentialsRotationWorkloadsSource(c, apiReader client.Reader, newList func() runtime.Object, watched func(namespace string) bool) *CredentialsRotationSource {
+	return &CredentialsRotationSource{
+		client:       c,
+		apiReader:    apiReader,
+		newList:      newList,
+		watched:      watched,
+		fingerprints: map[string]string{},
+	}
+}
+
+// InjectStopChannel is called by the controller before starting the source
+func (s *CredentialsRotationSource) InjectStopChannel(stop <-chan struct{}) error {
+	if s.stop == nil {
+		s.stop = stop
+	}
+	return nil
+}
+
+// Start polls the credentials Secrets when the pull Secrets are managed, the handler and the predicates are not used
+func (s *CredentialsRotationSource) Start(_ handler.EventHandler, queue workqueue.RateLimitingInterface, _ ...predicate.Predicate) error {
+	go func() {
+		ticker := time.NewTicker(credentialsPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if !config.Current().ManagePullSecrets {
+					continue
+				}
+				for _, r := range s.requests() {
+					queue.Add(r)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// requests returns the reconcile requests for the workloads of the namespaces whose credentials changed since the last call.
+// The first call only records the credentials.
+func (s *CredentialsRotationSource) requests() []reconcile.Request {
+	requests := []reconcile.Request{}
+	for _, ns := range s.rotated() {
+		if !s.watched(ns) {
+			continue
+		}
+		log.Info("Backup destination credentials changed, reconciling again", "Namespace", ns)
+		requests = append(requests, namespaceWorkloads(s.client, ns, s.newList())...)
+	}
+	return requests
+}
+
+// rotated returns the sorted namespaces whose credentials Secret changed since the last call
+func (s *CredentialsRotationSource) rotated() []string {
+	dests := &v1alpha1.BackupDestinationList{}
+	if err := s.client.List(context.Background(), dests); err != nil {
+		log.Error(err, "Failed to list the backup destinations")
+		return nil
+	}
+
+	rotated := []string{}
+	seen := map[string]bool{}
+	for _, d := range dests.Items {
+		if d.Name != v1alpha1.DefaultBackupDestinationName {
+			continue
+		}
+		seen[d.Namespace] = true
+		secret := &corev1.Secret{}
+		if err := s.apiReader.Get(context.Background(), types.NamespacedName{Namespace: d.Namespace, Name: d.Spec.CredentialsSecret}, secret); err != nil {
+			// reported when the workloads are reconciled
+			continue
+		}
+		fingerprint := credentialsFingerprint(secret)
+		if previous, known := s.fingerprints[d.Namespace]; known && previous != fingerprint {
+			rotated = append(rotated, d.Namespace)
+		}
+		s.fingerprints[d.Namespace] = fingerprint
+	}
+	for ns := range s.fingerprints {
+		if !seen[ns] {
+			delete(s.fingerprints, ns)
+		}
+	}
+	sort.Strings(rotated)
+	return rotated
+}
+
+// credentialsFingerprint returns a hash of the name and the credentials of the given Secret
+func credentialsFingerprint(secret *corev1.Secret) string {
+	h := sha256.New()
+	for _, value := range []string{
+		secret.Name,
+		string(secret.Data[v1alpha1.BackupDestinationUsernameKey]),
+		string(secret.Data[v1alpha1.BackupDestinationPasswordKey]),
+	} {
+		// length prefixed to tell the fields apart
+		fmt.Fprintf(h, "%d:%s", len(value), value)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestCredentialsRotationSource(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		t.Fatalf("Failed to build the scheme: %v", err)
+	}
+	if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+		t.Fatalf("Failed to build the scheme: %v", err)
+	}
+	objects := []runtime.Object{}
+	for _, ns := range []string{"tenant", "ignored"} {
+		objects = append(objects,
+			&v1alpha1.BackupDestination{
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: v1alpha1.DefaultBackupDestinationName},
+				Spec:       v1alpha1.BackupDestinationSpec{Registry: "quay.io", Organization: ns, CredentialsSecret: "creds"},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "creds"},
+				Data:       map[string][]byte{"username": []byte("user"), "password": []byte("pwd")},
+			},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "app"}},
+		)
+	}
+	cli := fake.NewFakeClientWithScheme(s, objects...)
+	src := NewCredentialsRotationWorkloadsSource(cli, cli, func() runtime.Object { return &appsv1.DeploymentList{} },
+		func(ns string) bool { return ns != "ignored" })
+
+	if requests := src.requests(); len(requests) != 0 {
+		t.Errorf("Expected no request on the first check, got %v", requests)
+	}
+	if requests := src.requests(); len(requests) != 0 {
+		t.Errorf("Expected no request without change, got %v", requests)
+	}
+
+	// rotating the credentials of both namespaces
+	for _, ns := range []string{"tenant", "ignored"} {
+		secret := &corev1.Secret{}
+		if err := cli.Get(context.Background(), types.NamespacedName{Namespace: ns, Name: "creds"}, secret); err != nil {
+			t.Fatalf("Failed to get the secret: %v", err)
+		}
+		secret.Data["password"] = []byte("rotated")
+		if err := cli.Update(context.Background(), secret); err != nil {
+			t.Fatalf("Failed to update the secret: %v", err)
+		}
+	}
+	expected := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "tenant", Name: "app"}}}
+	if requests := src.requests(); !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected %v, got %v", expected, requests)
+	}
+	if requests := src.requests(); len(requests) != 0 {
+		t.Errorf("Expected the rotation to be reported once, got %v", requests)
+	}
+}
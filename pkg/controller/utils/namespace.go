@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"context"
+
+	"image-clone-controller/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var log = logf.Log.WithName("utils")
+
+var _ predicate.Predicate = &NamespaceSelectorPredicate{}
+var _ predicate.Predicate = &NamespaceSelectionPredicate{}
+
+// NamespaceSelectorPredicate is a predicate to process events only from the namespaces matching the label selector.
+// Namespace labels are read at the event time, so the selection follows the label changes.
+type NamespaceSelectorPredicate struct {
+	client client.Reader
+	// namespace label selector (labels.Selector)
+	selector *config.Derived
+}
+
+// NewNamespaceSelectorPredicate returns an instance of NamespaceSelectorPredicate
+func NewNamespaceSelectorPredicate(c client.Reader, selector labels.Selector) *NamespaceSelectorPredicate {
+	return &NamespaceSelectorPredicate{
+		client:   c,
+		selector: config.Fixed(selector),
+	}
+}
+
+// NewNamespaceSelectorPredicateFromConfig returns an instance of NamespaceSelectorPredicate set from the program configuration,
+// the predicate follows the configuration changes
+func NewNamespaceSelectorPredicateFromConfig(c client.Reader) *NamespaceSelectorPredicate {
+	return &NamespaceSelectorPredicate{
+		client:   c,
+		selector: newLabelSelectorFromConfig(),
+	}
+}
+
+// Selected returns true if the given namespace matches the label selector
+func (p *NamespaceSelectorPredicate) Selected(namespace string) bool {
+	selector := p.selector.Get().(labels.Selector)
+	if selector.Empty() {
+		// no need to fetch the namespace
+		return true
+	}
+
+	ns := &corev1.Namespace{}
+	if err := p.client.Get(context.Background(), types.NamespacedName{Name: namespace}, ns); err != nil {
+		log.Error(err, "Failed to get the namespace", "Namespace", namespace)
+		return false
+	}
+	return selector.Matches(labels.Set(ns.Labels))
+}
+
+// Create returns true if the create event should be processed
+func (p *NamespaceSelectorPredicate) Create(e event.CreateEvent) bool {
+	return p.Selected(e.Meta.GetNamespace())
+}
+
+// Update returns true if the update event should be processed
+func (p *NamespaceSelectorPredicate) Update(e event.UpdateEvent) bool {
+	return p.Selected(e.MetaNew.GetNamespace())
+}
+
+// Delete returns true if the delete event should be processed
+func (p *NamespaceSelectorPredicate) Delete(e event.DeleteEvent) bool {
+	return p.Selected(e.Meta.GetNamespace())
+}
+
+// Generic returns true if the generic event should be processed
+func (p *NamespaceSelectorPredicate) Generic(e event.GenericEvent) bool {
+	return p.Selected(e.Meta.GetNamespace())
+}
+
+// NamespaceSelectionPredicate is a predicate to process the namespace events
+// only when the namespace enters the selection: its labels start matching the selector
+type NamespaceSelectionPredicate struct {
+	// namespace label selector (labels.Selector)
+	selector  *config.Derived
+	blacklist *BlacklistNamespacePredicate
+}
+
+// NewNamespaceSelectionPredicate returns an instance of NamespaceSelectionPredicate
+func NewNamespaceSelectionPredicate(selector labels.Selector, blacklist *BlacklistNamespacePredicate) *NamespaceSelectionPredicate {
+	return &NamespaceSelectionPredicate{
+		selector:  config.Fixed(selector),
+		blacklist: blacklist,
+	}
+}
+
+// NewNamespaceSelectionPredicateFromConfig returns an instance of NamespaceSelectionPredicate set from the program configuration,
+// the predicate follows the configuration changes
+func NewNamespaceSelectionPredicateFromConfig() *NamespaceSelectionPredicate {
+	return &NamespaceSelectionPredicate{
+		selector:  newLabelSelectorFromConfig(),
+		blacklist: NewBlacklistNamespacePredicateFromConfig(),
+	}
+}
+
+// Create returns false: a new namespace doesn't have any workloads yet
+func (p *NamespaceSelectionPredicate) Create(e event.CreateEvent) bool {
+	return false
+}
+
+// Update returns true if the namespace has just entered the selection
+func (p *NamespaceSelectionPredicate) Update(e event.UpdateEvent) bool {
+	if !p.blacklist.Watched(e.MetaNew.GetName()) {
+		return false
+	}
+	selector := p.selector.Get().(labels.Selector)
+	return !selector.Matches(labels.Set(e.MetaOld.GetLabels())) && selector.Matches(labels.Set(e.MetaNew.GetLabels()))
+}
+
+// Delete returns false: nothing to reconcile in a deleted namespace
+func (p *NamespaceSelectionPredicate) Delete(e event.DeleteEvent) bool {
+	return false
+}
+
+// Generic returns false
+func (p *NamespaceSelectionPredicate) Generic(e event.GenericEvent) bool {
+	return false
+}
+
+// newLabelSelectorFromConfig returns the namespace label selector following the program's config
+func newLabelSelectorFromConfig() *config.Derived {
+	return config.NewDerived(func(c *config.Config) interface{} {
+		return c.NamespaceLabelSelector()
+	})
+}
+
+// NewNamespaceWorkloadsHandler returns an event handler which enqueues all the workloads of the namespace
+// the event was received for. newList should return an empty list of the workloads to enqueue.
+func NewNamespaceWorkloadsHandler(c client.Reader, newList func() runtime.Object) handler.EventHandler {
+	return &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			return namespaceWorkloads(c, o.Meta.GetName(), newList())
+		}),
+	}
+}
+
+// NewObjectNamespaceWorkloadsHandler returns an event handler which enqueues all the workloads of the namespace
+// of the object the event was received for. newList should return an empty list of the workloads to enqueue.
+func NewObjectNamespaceWorkloadsHandler(c client.Reader, newList func() runtime.Object) handler.EventHandler {
+	return &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			return namespaceWorkloads(c, o.Meta.GetNamespace(), newList())
+		}),
+	}
+}
+
+// namespaceWorkloads returns the reconcile requests for all the workloads from the given namespace
+func namespaceWorkloads(c client.Reader, namespace string, list runtime.Object) []reconcile.Request {
+	if err := c.List(context.Background(), list, client.InNamespace(namespace)); err != nil {
+		log.Error(err, "Failed to list the workloads", "Namespace", namespace)
+		return nil
+	}
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		log.Error(err, "Failed to extract the workloads", "Namespace", namespace)
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, item := range items {
+		if m, err := meta.Accessor(item); err == nil {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: m.GetNamespace(),
+					Name:      m.GetName(),
+				},
+			})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"sort"
+	"testing"
+
+	"image-clone-controller/pkg/config"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestNamespaceSelectorPredicate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		selector string
+		ns       string
+		expected bool
+	}{
+		{
+			name:     "No selector",
+			selector: "",
+			ns:       "unknown",
+			expected: true,
+		},
+		{
+			name:     "Matching",
+			selector: "image-clone=enabled",
+			ns:       "enabled",
+			expected: true,
+		},
+		{
+			name:     "Not matching",
+			selector: "image-clone=enabled",
+			ns:       "disabled",
+			expected: false,
+		},
+		{
+			name:     "Not matching exclusion",
+			selector: "image-clone=enabled,team!=platform",
+			ns:       "platform",
+			expected: false,
+		},
+		{
+			name:     "Unknown namespace",
+			selector: "image-clone=enabled",
+			ns:       "unknown",
+			expected: false,
+		},
+	}
+
+	cli := fake.NewFakeClient(
+		newTestNamespace("enabled", map[string]string{"image-clone": "enabled"}),
+		newTestNamespace("disabled", map[string]string{"image-clone": "disabled"}),
+		newTestNamespace("platform", map[string]string{"image-clone": "enabled", "team": "platform"}),
+	)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			selector, err := labels.Parse(tc.selector)
+			if err != nil {
+				t.Fatalf("Failed to parse the selector: %v", err)
+			}
+			pred := NewNamespaceSelectorPredicate(cli, selector)
+			output := pred.Create(newTestCreateEvent(tc.ns))
+			if output != tc.expected {
+				t.Errorf("Create event not handled correctly. Expected %t, got %t", tc.expected, output)
+			}
+			output = pred.Update(newTestUpdateEvent(tc.ns))
+			if output != tc.expected {
+				t.Errorf("Update event not handled correctly. Expected %t, got %t", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestNamespaceSelectionPredicate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		blacklist []string
+		oldLabels map[string]string
+		newLabels map[string]string
+		expected  bool
+	}{
+		{
+			name:      "Entering",
+			oldLabels: map[string]string{},
+			newLabels: map[string]string{"image-clone": "enabled"},
+			expected:  true,
+		},
+		{
+			name:      "Already selected",
+			oldLabels: map[string]string{"image-clone": "enabled"},
+			newLabels: map[string]string{"image-clone": "enabled", "other": "label"},
+			expected:  false,
+		},
+		{
+			name:      "Leaving",
+			oldLabels: map[string]string{"image-clone": "enabled"},
+			newLabels: map[string]string{},
+			expected:  false,
+		},
+		{
+			name:      "Entering blacklisted",
+			blacklist: []string{"ns"},
+			oldLabels: map[string]string{},
+			newLabels: map[string]string{"image-clone": "enabled"},
+			expected:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			blacklist, err := config.NewNamespaceMatcher(tc.blacklist)
+			if err != nil {
+				t.Fatalf("Failed to create the blacklist: %v", err)
+			}
+			pred := NewNamespaceSelectionPredicate(labels.SelectorFromSet(labels.Set{"image-clone": "enabled"}), NewBlacklistNamespacePredicate(blacklist, nil))
+			output := pred.Update(event.UpdateEvent{
+				MetaOld: &metav1.ObjectMeta{Name: "ns", Labels: tc.oldLabels},
+				MetaNew: &metav1.ObjectMeta{Name: "ns", Labels: tc.newLabels},
+			})
+			if output != tc.expected {
+				t.Errorf("Update event not handled correctly. Expected %t, got %t", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestNamespaceWorkloads(t *testing.T) {
+	cli := fake.NewFakeClient(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "one"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "two"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "three"}},
+	)
+
+	requests := namespaceWorkloads(cli, "ns", &appsv1.DeploymentList{})
+	names := []string{}
+	for _, r := range requests {
+		names = append(names, r.String())
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "ns/one" || names[1] != "ns/two" {
+		t.Errorf("Expected [ns/one ns/two], got %v", names)
+	}
+}
+
+func newTestNamespace(name string, l map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: l,
+		},
+	}
+}
@@ -8,45 +8,61 @@ import (
 
 var _ predicate.Predicate = &BlacklistNamespacePredicate{}
 
-// BlacklistNamespacePredicate is a predicate to not process events from the blacklisted namespaces
+// BlacklistNamespacePredicate is a predicate to not process events from the blacklisted namespaces.
+// If the whitelist is not empty only the events from the whitelisted namespaces are processed.
 type BlacklistNamespacePredicate struct {
-	list map[string]bool
+	// namespace lists (namespaceLists)
+	lists *config.Derived
+}
+
+// namespaceLists are the namespace black and white lists
+type namespaceLists struct {
+	blacklist *config.NamespaceMatcher
+	whitelist *config.NamespaceMatcher
 }
 
 // NewBlacklistNamespacePredicate returns an instance of BlacklistNamespacePredicate
-func NewBlacklistNamespacePredicate(blacklist map[string]bool) *BlacklistNamespacePredicate {
+func NewBlacklistNamespacePredicate(blacklist, whitelist *config.NamespaceMatcher) *BlacklistNamespacePredicate {
 	return &BlacklistNamespacePredicate{
-		list: blacklist,
+		lists: config.Fixed(namespaceLists{blacklist: blacklist, whitelist: whitelist}),
 	}
 }
 
-// NewBlacklistNamespacePredicateFromConfig returns an instance of BlacklistNamespacePredicate set from the program configuration
+// NewBlacklistNamespacePredicateFromConfig returns an instance of BlacklistNamespacePredicate set from the program configuration,
+// the predicate follows the configuration changes
 func NewBlacklistNamespacePredicateFromConfig() *BlacklistNamespacePredicate {
 	return &BlacklistNamespacePredicate{
-		list: config.GlobalConfig.NamespaceBlacklist(),
+		lists: config.NewDerived(func(c *config.Config) interface{} {
+			return namespaceLists{blacklist: c.NamespaceBlacklist(), whitelist: c.NamespaceWhitelist()}
+		}),
+	}
+}
+
+// Watched returns true if the given namespace is not blacklisted and is whitelisted if there is a whitelist
+func (p *BlacklistNamespacePredicate) Watched(namespace string) bool {
+	lists := p.lists.Get().(namespaceLists)
+	if lists.blacklist.Matches(namespace) {
+		return false
 	}
+	return lists.whitelist.Empty() || lists.whitelist.Matches(namespace)
 }
 
 // Create returns true if the create event should be processed
 func (p *BlacklistNamespacePredicate) Create(e event.CreateEvent) bool {
-	_, exists := p.list[e.Meta.GetNamespace()]
-	return !exists
+	return p.Watched(e.Meta.GetNamespace())
 }
 
 // Update returns true if the update event should be processed
 func (p *BlacklistNamespacePredicate) Update(e event.UpdateEvent) bool {
-	_, exists := p.list[e.MetaNew.GetNamespace()]
-	return !exists
+	return p.Watched(e.MetaNew.GetNamespace())
 }
 
 // Delete returns true if the delete event should be processed
 func (p *BlacklistNamespacePredicate) Delete(e event.DeleteEvent) bool {
-	_, exists := p.list[e.Meta.GetNamespace()]
-	return !exists
+	return p.Watched(e.Meta.GetNamespace())
 }
 
 // Generic  returns true if the generic event should be processed
 func (p *BlacklistNamespacePredicate) Generic(e event.GenericEvent) bool {
-	_, exists := p.list[e.Meta.GetNamespace()]
-	return !exists
+	return p.Watched(e.Meta.GetNamespace())
 }
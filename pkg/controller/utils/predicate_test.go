@@ -3,6 +3,8 @@ package utils
 import (
 	"testing"
 
+	"image-clone-controller/pkg/config"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 )
@@ -10,33 +12,79 @@ import (
 func TestBlacklistNamespacePredicate(t *testing.T) {
 	testCases := []struct {
 		name      string
-		blacklist map[string]bool
+		blacklist []string
+		whitelist []string
 		ns        string
 		expected  bool
 	}{
 		{
 			name:      "Go",
-			blacklist: map[string]bool{"bad": true},
+			blacklist: []string{"bad"},
 			ns:        "good",
 			expected:  true,
 		},
 		{
 			name:      "No go",
-			blacklist: map[string]bool{"bad": true},
+			blacklist: []string{"bad"},
 			ns:        "bad",
 			expected:  false,
 		},
 		{
 			name:      "No go 2",
-			blacklist: map[string]bool{"bad": true, "too bad": true},
+			blacklist: []string{"bad", "too bad"},
 			ns:        "bad",
 			expected:  false,
 		},
+		{
+			name:      "No go glob",
+			blacklist: []string{"ci-*", "*-system"},
+			ns:        "kube-system",
+			expected:  false,
+		},
+		{
+			name:      "No go regexp",
+			blacklist: []string{"/preview-pr-[0-9]+/"},
+			ns:        "preview-pr-42",
+			expected:  false,
+		},
+		{
+			name:      "Go regexp",
+			blacklist: []string{"/preview-pr-[0-9]+/"},
+			ns:        "preview-pr-42-db",
+			expected:  true,
+		},
+		{
+			name:      "Go whitelisted",
+			whitelist: []string{"team-*"},
+			ns:        "team-a",
+			expected:  true,
+		},
+		{
+			name:      "No go not whitelisted",
+			whitelist: []string{"team-*"},
+			ns:        "default",
+			expected:  false,
+		},
+		{
+			name:      "No go whitelisted but blacklisted",
+			blacklist: []string{"team-b"},
+			whitelist: []string{"team-*"},
+			ns:        "team-b",
+			expected:  false,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			pred := NewBlacklistNamespacePredicate(tc.blacklist)
+			blacklist, err := config.NewNamespaceMatcher(tc.blacklist)
+			if err != nil {
+				t.Fatalf("Failed to create the blacklist: %v", err)
+			}
+			whitelist, err := config.NewNamespaceMatcher(tc.whitelist)
+			if err != nil {
+				t.Fatalf("Failed to create the whitelist: %v", err)
+			}
+			pred := NewBlacklistNamespacePredicate(blacklist, whitelist)
 			output := pred.Create(newTestCreateEvent(tc.ns))
 			if output != tc.expected {
 				t.Errorf("Create event not handled correctly. Expected %t, got %t", tc.expected, output)
@@ -0,0 +1,164 @@
+package workload
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/metrics"
+)
+
+const (
+	// UpdateHistoryAnnotation is the workload annotation with the history of its updates used to detect the conflicts, as a JSON object.
+	// A workload in conflict is not updated anymore until the annotation is removed.
+	UpdateHistoryAnnotation = "imageclone.io/update-history"
+)
+
+var (
+	// the limits are shared by all the workload controllers
+	sharedConflictTracker     *ConflictTracker
+	sharedConflictTrackerOnce sync.Once
+)
+
+// ConflictTracker detects the workloads whose updates are reverted by another tool (e.g. a GitOps tool or an operator).
+// A workload reverted too many times within a time window is in conflict: it's not updated anymore.
+// The history of the updates is kept in an annotation of the workload, so it survives the restarts of the controller.
+type ConflictTracker struct {
+	// revert limits (revertLimits)
+	limits *config.Derived
+}
+
+// revertLimits are the number of the reverts within the window putting a workload in conflict
+type revertLimits struct {
+	maxReverts int
+	window     time.Duration
+}
+
+// newRevertLimits returns the limits set in the given config
+func newRevertLimits(c *config.Config) interface{} {
+	return revertLimits{maxReverts: c.MaxUpdateReverts, window: c.UpdateRevertWindow}
+}
+
+// updateHistory is the history of the updates of a workload
+type updateHistory struct {
+	// source images replaced by the last update by container name
+	Migrated map[string]string `json:"migrated,omitempty"`
+	// times of the reverts within the window
+	Reverts  []time.Time `json:"reverts,omitempty"`
+	Conflict bool        `json:"conflict,omitempty"`
+}
+
+// NewConflictTracker returns a tracker putting the workloads in conflict after the given number of reverts
+// within the given window, 0 disables the detection
+func NewConflictTracker(maxReverts int, window time.Duration) *ConflictTracker {
+	return &ConflictTracker{
+		limits: config.Fixed(revertLimits{maxReverts: maxReverts, window: window}),
+	}
+}
+
+// NewConflictTrackerFromConfig returns a tracker set from the program's config, the tracker follows the configuration changes
+func NewConflictTrackerFromConfig() *ConflictTracker {
+	return &ConflictTracker{
+		limits: config.NewDerived(newRevertLimits),
+	}
+}
+
+// sharedConflictTrackerFromConfig returns the tracker shared by all the workload controllers, set from the program's config
+func sharedConflictTrackerFromConfig() *ConflictTracker {
+	sharedConflictTrackerOnce.Do(func() {
+		sharedConflictTracker = NewConflictTrackerFromConfig()
+	})
+	return sharedConflictTracker
+}
+
+// Check is called before the workload is updated with the given source images replaced by container name.
+// Replacing again the images replaced by the previous update means that it was reverted.
+// Returns true if the workload is in conflict and true as well if the conflict was detected by this call.
+// The history annotation of the workload is updated with the revert: it's saved with the update of the workload,
+// a newly detected conflict has to be saved by the caller.
+func (t *ConflictTracker) Check(w *Workload, changed map[string]string) (bool, bool) {
+	limits := t.limits.Get().(revertLimits)
+	h, exists := updateHistoryOf(w)
+	if limits.maxReverts <= 0 || !exists {
+		metrics.WorkloadInConflict(w.Kind, w.NamespacedName().String(), false)
+		return false, false
+	}
+	if h.Conflict {
+		metrics.WorkloadInConflict(w.Kind, w.NamespacedName().String(), true)
+		return true, false
+	}
+
+	reverted := false
+	for container, image := range changed {
+		if h.Migrated[container] == image {
+			reverted = true
+		}
+	}
+	if !reverted {
+		return false, false
+	}
+	metrics.WorkloadReverted(w.Kind)
+
+	// only the reverts within the window count
+	t0 := now()
+	reverts := []time.Time{t0}
+	for _, r := range h.Reverts {
+		if t0.Sub(r) < limits.window {
+			reverts = append(reverts, r)
+		}
+	}
+	h.Reverts = reverts
+	h.Conflict = len(reverts) >= limits.maxReverts
+	setUpdateHistory(w, h)
+	if !h.Conflict {
+		return false, false
+	}
+	metrics.WorkloadInConflict(w.Kind, w.NamespacedName().String(), true)
+	return true, true
+}
+
+// Migrated records in the history annotation of the workload the update replacing the given source images by container name,
+// called before the workload is updated
+func (t *ConflictTracker) Migrated(w *Workload, changed map[string]string) {
+	if t.limits.Get().(revertLimits).maxReverts <= 0 {
+		return
+	}
+	h, _ := updateHistoryOf(w)
+	h.Migrated = changed
+	setUpdateHistory(w, h)
+}
+
+// Forget clears the state kept for a deleted workload
+func (t *ConflictTracker) Forget(kind, key string) {
+	metrics.WorkloadInConflict(kind, key, false)
+}
+
+// saveUpdateHistory saves the history annotation of the workload without the other changes of the workload
+func (m *Migrator) saveUpdateHistory(w *Workload) error {
+	return patchAnnotation(m.client, w, UpdateHistoryAnnotation, w.Object.GetAnnotations()[UpdateHistoryAnnotation])
+}
+
+// updateHistoryOf returns the history of the updates of the workload from its annotation, false if there is none
+func updateHistoryOf(w *Workload) (*updateHistory, bool) {
+	h := &updateHistory{}
+	annotation, exists := w.Object.GetAnnotations()[UpdateHistoryAnnotation]
+	if !exists {
+		return h, false
+	}
+	// an invalid annotation only restarts the history
+	if err := json.Unmarshal([]byte(annotation), h); err != nil {
+		return &updateHistory{}, false
+	}
+	return h, true
+}
+
+// setUpdateHistory records the history of the updates in the annotation of the workload
+func setUpdateHistory(w *Workload, h *updateHistory) {
+	value, err := json.Marshal(h)
+	if err != nil {
+		// can't happen: only maps, times and booleans
+		return
+	}
+	setAnnotation(w, UpdateHistoryAnnotation, string(value))
+}
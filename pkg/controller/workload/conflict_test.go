@@ -0,0 +1,123 @@
+package workload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/registry"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestMigrateConflictSaved(t *testing.T) {
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return time.Date(2020, 3, 4, 12, 0, 0, 0, time.UTC) }
+
+	syncTime := metav1.Now()
+	backedUp := &v1alpha1.ImageBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.ImageBackupName("nginx")},
+		Spec:       v1alpha1.ImageBackupSpec{Source: "nginx"},
+		Status:     v1alpha1.ImageBackupStatus{Source: "nginx", Destination: "quay.io/org/nginx", LastSyncTime: &syncTime},
+	}
+	deploy := newTestDeployment("ns", "app", "nginx")
+	// the previous update replaced nginx, it was reverted
+	deploy.Annotations = map[string]string{UpdateHistoryAnnotation: `{"migrated":{"a":"nginx"}}`}
+	cli := fake.NewFakeClientWithScheme(newTestScheme(t), backedUp, deploy, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}})
+	m := &Migrator{
+		client:    cli,
+		apiReader: cli,
+		regClient: registry.NewClient("quay.io", "org", "", "", 0),
+		rules:     config.Fixed(&config.ImageRuleSet{}),
+		recorder:  record.NewFakeRecorder(10),
+		conflicts: NewConflictTracker(1, 10*time.Minute),
+	}
+
+	if _, err := m.Migrate(logf.Log, FromObject(deploy.DeepCopy())); err != nil {
+		t.Fatalf("Got not expected error: %v", err)
+	}
+	saved := &appsv1.Deployment{}
+	if err := cli.Get(context.Background(), types.NamespacedName{Namespace: "ns", Name: "app"}, saved); err != nil {
+		t.Fatalf("Failed to get the deployment: %v", err)
+	}
+	if saved.Spec.Template.Spec.Containers[0].Image != "nginx" {
+		t.Errorf("Expected the deployment in conflict not to be updated, got %s", saved.Spec.Template.Spec.Containers[0].Image)
+	}
+	if h, _ := updateHistoryOf(FromObject(saved)); !h.Conflict {
+		t.Errorf("Expected the conflict to be saved in the annotation, got %q", saved.Annotations[UpdateHistoryAnnotation])
+	}
+}
+
+func TestConflictTracker(t *testing.T) {
+	defer func(clock func() time.Time) { now = clock }(now)
+	start := time.Date(2020, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name             string
+		maxReverts       int
+		updates          []map[string]string
+		interval         time.Duration
+		expectedConflict bool
+	}{
+		{
+			name:       "Detection disabled",
+			maxReverts: 0,
+			updates:    []map[string]string{{"a": "nginx"}, {"a": "nginx"}, {"a": "nginx"}},
+		},
+		{
+			name:       "Not reverted",
+			maxReverts: 2,
+			updates:    []map[string]string{{"a": "nginx"}, {"a": "nginx:1.19"}, {"a": "nginx:1.20"}},
+		},
+		{
+			name:             "Reverted",
+			maxReverts:       2,
+			updates:          []map[string]string{{"a": "nginx"}, {"a": "nginx"}, {"a": "nginx"}},
+			expectedConflict: true,
+		},
+		{
+			name:       "Reverts out of the window",
+			maxReverts: 2,
+			updates:    []map[string]string{{"a": "nginx"}, {"a": "nginx"}, {"a": "nginx"}},
+			interval:   15 * time.Minute,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := NewConflictTracker(tc.maxReverts, 10*time.Minute)
+			w := FromObject(newTestDeployment("ns", "app", "nginx"))
+
+			inConflict := false
+			for i, changed := range tc.updates {
+				now = func() time.Time { return start.Add(time.Duration(i) * tc.interval) }
+				if inConflict, _ = tracker.Check(w, changed); inConflict {
+					break
+				}
+				tracker.Migrated(w, changed)
+			}
+			if inConflict != tc.expectedConflict {
+				t.Errorf("Expected conflict: %v, got: %v", tc.expectedConflict, inConflict)
+			}
+
+			// the history is kept in the workload: a restarted controller sees the conflict
+			restarted := NewConflictTracker(tc.maxReverts, 10*time.Minute)
+			if inConflict, _ := restarted.Check(w, tc.updates[0]); tc.expectedConflict && !inConflict {
+				t.Errorf("Expected the conflict to be kept after a restart")
+			}
+
+			removeAnnotation(w, UpdateHistoryAnnotation)
+			if inConflict, _ := tracker.Check(w, tc.updates[0]); inConflict {
+				t.Errorf("Expected no conflict once the annotation is removed")
+			}
+		})
+	}
+}
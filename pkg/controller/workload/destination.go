@@ -0,0 +1,46 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/registry"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NamespaceRegistryClient returns the registry client for the BackupDestination of the given namespace,
+// nil if the namespace has no BackupDestination: the global backup registry is used then.
+// The credentials Secret is read with secrets, the API reader in the controllers: the Secrets are not cached.
+func NamespaceRegistryClient(c, secrets client.Reader, namespace string) (*registry.Client, error) {
+	if namespace == "" {
+		return nil, nil
+	}
+
+	dest := &v1alpha1.BackupDestination{}
+	err := c.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: v1alpha1.DefaultBackupDestinationName}, dest)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{}
+	if err := secrets.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: dest.Spec.CredentialsSecret}, secret); err != nil {
+		return nil, fmt.Errorf("failed to get the credentials secret %s/%s: %v", namespace, dest.Spec.CredentialsSecret, err)
+	}
+	username := string(secret.Data[v1alpha1.BackupDestinationUsernameKey])
+	password := string(secret.Data[v1alpha1.BackupDestinationPasswordKey])
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("credentials secret %s/%s should have %q and %q keys", namespace, dest.Spec.CredentialsSecret,
+			v1alpha1.BackupDestinationUsernameKey, v1alpha1.BackupDestinationPasswordKey)
+	}
+
+	return registry.NewClient(dest.Spec.Registry, dest.Spec.Organization, username, password, config.Current().ImageCopyTimeoutSeconds), nil
+}
@@ -0,0 +1,69 @@
+package workload
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/metrics"
+	"image-clone-controller/pkg/registry"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// delay before checking again if the upstream images of the failed over workloads are available
+	sourceCheckDelay = 5 * time.Minute
+)
+
+// sourceAvailable returns true if the given image can be pulled from its source registry
+var sourceAvailable = func(regClient *registry.Client, image string) bool {
+	_, err := regClient.InspectSource(image)
+	return err == nil
+}
+
+// failingPulls returns the images of the workload's containers its pods fail to pull by container name.
+// Only the images of the current pod template are considered.
+func (m *Migrator) failingPulls(w *Workload) (map[string]string, error) {
+	pods, err := w.Pods(m.client)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]string{}
+	for _, c := range w.Template.Spec.Containers {
+		current[c.Name] = c.Image
+	}
+	failing := map[string]string{}
+	for i := range pods {
+		for container, image := range PullFailures(&pods[i]) {
+			if current[container] == image {
+				failing[container] = image
+			}
+		}
+	}
+	return failing, nil
+}
+
+// failoverEvents emits the events for the containers switched to the backup images
+// and the ones switched back to the upstream images in failover mode
+func (m *Migrator) failoverEvents(w *Workload, changed map[string]string, restored []string, cfg *config.Config) {
+	if !cfg.FailoverMode {
+		return
+	}
+	if len(changed) > 0 {
+		containers := []string{}
+		for container := range changed {
+			containers = append(containers, container)
+		}
+		sort.Strings(containers)
+		metrics.WorkloadFailedOver(w.Kind, metrics.DirectionBackup)
+		m.recorder.Eventf(w.Object, corev1.EventTypeWarning, "FailedOver", "Upstream images of containers %s fail to be pulled, switched to the backup images",
+			strings.Join(containers, ", "))
+	}
+	if len(restored) > 0 {
+		metrics.WorkloadFailedOver(w.Kind, metrics.DirectionUpstream)
+		m.recorder.Eventf(w.Object, corev1.EventTypeNormal, "SwitchedBack", "Upstream images of containers %s are available again, switched back to them",
+			strings.Join(restored, ", "))
+	}
+}
@@ -0,0 +1,137 @@
+package workload
+
+import (
+	"context"
+	"testing"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/registry"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestMigrateFailover(t *testing.T) {
+	defer func(available func(*registry.Client, string) bool) { sourceAvailable = available }(sourceAvailable)
+
+	syncTime := metav1.Now()
+	backedUp := &v1alpha1.ImageBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.ImageBackupName("nginx")},
+		Spec:       v1alpha1.ImageBackupSpec{Source: "nginx"},
+		Status: v1alpha1.ImageBackupStatus{
+			Source:       "nginx",
+			Destination:  "quay.io/org/nginx",
+			LastSyncTime: &syncTime,
+		},
+	}
+
+	testCases := []struct {
+		name              string
+		image             string
+		originals         string
+		podReason         string
+		switchBack        bool
+		upstreamAvailable bool
+		expectedImage     string
+		expectedOriginals string
+		expectedRequeue   bool
+	}{
+		{
+			name:          "Upstream image pulled",
+			image:         "nginx",
+			podReason:     "ContainerCreating",
+			expectedImage: "nginx",
+		},
+		{
+			name:              "Upstream image failing",
+			image:             "nginx",
+			podReason:         "ImagePullBackOff",
+			expectedImage:     "quay.io/org/nginx",
+			expectedOriginals: `{"a":"nginx"}`,
+		},
+		{
+			name:              "Failed over without switch back",
+			image:             "quay.io/org/nginx",
+			originals:         `{"a":"nginx"}`,
+			upstreamAvailable: true,
+			expectedImage:     "quay.io/org/nginx",
+			expectedOriginals: `{"a":"nginx"}`,
+		},
+		{
+			name:              "Upstream image still unavailable",
+			image:             "quay.io/org/nginx",
+			originals:         `{"a":"nginx"}`,
+			switchBack:        true,
+			expectedImage:     "quay.io/org/nginx",
+			expectedOriginals: `{"a":"nginx"}`,
+			expectedRequeue:   true,
+		},
+		{
+			name:              "Switched back",
+			image:             "quay.io/org/nginx",
+			originals:         `{"a":"nginx"}`,
+			switchBack:        true,
+			upstreamAvailable: true,
+			expectedImage:     "nginx",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			applyTestConfig(t, func(c *config.Config) {
+				c.FailoverMode = true
+				c.FailoverSwitchBack = tc.switchBack
+			})
+			defer applyTestConfig(t, func(c *config.Config) {})
+			sourceAvailable = func(*registry.Client, string) bool { return tc.upstreamAvailable }
+
+			deploy := newTestDeployment("ns", "app", tc.image)
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}}
+			if tc.originals != "" {
+				deploy.Annotations = map[string]string{OriginalImagesAnnotation: tc.originals}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1", Labels: map[string]string{"app": "app"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "a", Image: tc.image}}},
+				Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "a",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: tc.podReason}},
+				}}},
+			}
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}}
+			cli := fake.NewFakeClientWithScheme(newTestScheme(t), backedUp.DeepCopy(), deploy, pod, ns)
+			m := &Migrator{
+				client:    cli,
+				apiReader: cli,
+				regClient: registry.NewClient("quay.io", "org", "", "", 0),
+				rules:     config.Fixed(&config.ImageRuleSet{}),
+				recorder:  record.NewFakeRecorder(10),
+			}
+
+			result, err := m.Migrate(logf.Log, FromObject(deploy))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tc.expectedRequeue != (result.RequeueAfter > 0) {
+				t.Errorf("Expected requeue: %v, got: %v", tc.expectedRequeue, result.RequeueAfter)
+			}
+
+			output := &appsv1.Deployment{}
+			if err := cli.Get(context.Background(), types.NamespacedName{Namespace: "ns", Name: "app"}, output); err != nil {
+				t.Fatalf("Failed to get the deployment: %v", err)
+			}
+			if image := output.Spec.Template.Spec.Containers[0].Image; image != tc.expectedImage {
+				t.Errorf("Expected image %q, got %q", tc.expectedImage, image)
+			}
+			if originals := output.Annotations[OriginalImagesAnnotation]; originals != tc.expectedOriginals {
+				t.Errorf("Expected original images %q, got %q", tc.expectedOriginals, originals)
+			}
+		})
+	}
+}
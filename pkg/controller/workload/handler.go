@@ -0,0 +1,78 @@
+package workload
+
+import (
+	"context"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// NewImageBackupHandler returns an event handler which enqueues the workloads of the given kind
+// referenced by the ImageBackup once it's backed up. Only the workloads from the watched namespaces are enqueued.
+func NewImageBackupHandler(kind string, watched func(namespace string) bool) handler.EventHandler {
+	return &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			backup, ok := o.Object.(*v1alpha1.ImageBackup)
+			if !ok || !backup.BackedUp() {
+				return nil
+			}
+			return backupWorkloads(backup, kind, watched)
+		}),
+	}
+}
+
+// backupWorkloads returns the reconcile requests for the workloads of the given kind referenced by the ImageBackup
+func backupWorkloads(backup *v1alpha1.ImageBackup, kind string, watched func(namespace string) bool) []reconcile.Request {
+	requests := []reconcile.Request{}
+	for _, w := range backup.Status.Workloads {
+		if w.Kind != kind || !watched(w.Namespace) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Namespace: w.Namespace,
+				Name:      w.Name,
+			},
+		})
+	}
+	return requests
+}
+
+// NewPodPullFailureHandler returns an event handler which enqueues the workload of the given kind
+// owning the pod when the pod fails to pull an image
+func NewPodPullFailureHandler(c client.Reader, kind string) handler.EventHandler {
+	return &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			pod, ok := o.Object.(*corev1.Pod)
+			if !ok || len(PullFailures(pod)) == 0 {
+				return nil
+			}
+			owner := podOwner(c, pod)
+			if owner == nil || owner.Kind != kind {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name}}}
+		}),
+	}
+}
+
+// podOwner returns the reference to the workload controlling the pod, nil if it's not controlled by a workload.
+// The pods of the Deployments are controlled through a ReplicaSet.
+func podOwner(c client.Reader, pod *corev1.Pod) *metav1.OwnerReference {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "ReplicaSet" {
+		return owner
+	}
+	rs := &appsv1.ReplicaSet{}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name}, rs); err != nil {
+		return nil
+	}
+	return metav1.GetControllerOf(rs)
+}
@@ -0,0 +1,50 @@
+package workload
+
+import (
+	"reflect"
+	"testing"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestBackupWorkloads(t *testing.T) {
+	backup := &v1alpha1.ImageBackup{
+		Status: v1alpha1.ImageBackupStatus{
+			Workloads: []v1alpha1.WorkloadReference{
+				{Kind: KindDaemonSet, Namespace: "ns", Name: "ds"},
+				{Kind: KindDeployment, Namespace: "blacklisted", Name: "deploy"},
+				{Kind: KindDeployment, Namespace: "ns", Name: "deploy"},
+			},
+		},
+	}
+	watched := func(ns string) bool { return ns != "blacklisted" }
+
+	testCases := []struct {
+		name     string
+		kind     string
+		expected []reconcile.Request
+	}{
+		{
+			name:     "Deployments",
+			kind:     KindDeployment,
+			expected: []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "deploy"}}},
+		},
+		{
+			name:     "DaemonSets",
+			kind:     KindDaemonSet,
+			expected: []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "ds"}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := backupWorkloads(backup, tc.kind, watched)
+			if !reflect.DeepEqual(output, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, output)
+			}
+		})
+	}
+}
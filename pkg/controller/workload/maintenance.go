@@ -0,0 +1,64 @@
+package workload
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/schedule"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// MaintenanceWindowsAnnotation is the namespace annotation with the maintenance windows separated by semicolons,
+	// they replace the global ones for the workloads of the namespace
+	MaintenanceWindowsAnnotation = "imageclone.io/maintenance-windows"
+	// delay before checking again if the windows never open
+	noWindowDelay = time.Hour
+)
+
+// now is the clock of the maintenance windows
+var now = func() time.Time { return time.Now().UTC() }
+
+// maintenanceDelay returns 0 if the workload can be updated now, the delay until the next maintenance window otherwise
+func (m *Migrator) maintenanceDelay(logger logr.Logger, w *Workload, cfg *config.Config) (time.Duration, error) {
+	windows, err := m.maintenanceWindows(w, cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	t := now()
+	if windows.Open(t) {
+		return 0, nil
+	}
+	opening, found := windows.NextOpening(t)
+	if !found {
+		logger.Info("Maintenance windows never open")
+		return noWindowDelay, nil
+	}
+	return opening.Sub(t), nil
+}
+
+// maintenanceWindows returns the maintenance windows of the workload's namespace if it has some, the global ones otherwise
+func (m *Migrator) maintenanceWindows(w *Workload, cfg *config.Config) (schedule.Windows, error) {
+	ns := &corev1.Namespace{}
+	if err := m.client.Get(context.Background(), types.NamespacedName{Name: w.Object.GetNamespace()}, ns); err != nil {
+		return nil, err
+	}
+	annotation, exists := ns.Annotations[MaintenanceWindowsAnnotation]
+	if !exists {
+		return cfg.MaintenanceSchedule(), nil
+	}
+
+	windows, err := schedule.ParseWindows(strings.Split(annotation, ";"))
+	if err != nil {
+		// not updating the workload out of the windows the namespace owner wanted
+		m.recorder.Eventf(w.Object, corev1.EventTypeWarning, "InvalidMaintenanceWindows", "Namespace maintenance windows are not valid: %v", err)
+		return nil, err
+	}
+	return windows, nil
+}
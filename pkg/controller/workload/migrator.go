@@ -0,0 +1,485 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/metrics"
+	"image-clone-controller/pkg/registry"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Migrator migrates the workloads to the backed up images
+type Migrator struct {
+	// split client (reads from the cache, writes to API)
+	client client.Client
+	// reads the Secrets from the API: caching them would need to watch all the Secrets of the cluster
+	apiReader client.Reader
+	regClient *registry.Client
+	// image rules (*config.ImageRuleSet)
+	rules     *config.Derived
+	recorder  record.EventRecorder
+	pacer     *Pacer
+	conflicts *ConflictTracker
+}
+
+// NewMigratorFromConfig returns a migrator set from the program's config,
+// events are emitted on behalf of the given controller
+func NewMigratorFromConfig(mgr manager.Manager, controllerName string) *Migrator {
+	return &Migrator{
+		client:    mgr.GetClient(),
+		apiReader: mgr.GetAPIReader(),
+		regClient: registry.NewClientFromConfig(),
+		rules: config.NewDerived(func(c *config.Config) interface{} {
+			return c.ImageRuleSet()
+		}),
+		recorder:  mgr.GetEventRecorderFor(controllerName),
+		pacer:     sharedPacerFromConfig(mgr.GetClient()),
+		conflicts: sharedConflictTrackerFromConfig(),
+	}
+}
+
+// imageRules returns the image rules, rebuilt if the program's config changed
+func (m *Migrator) imageRules() *config.ImageRuleSet {
+	rules, _ := m.rules.Get().(*config.ImageRuleSet)
+	return rules
+}
+
+// migration is the state of the migration of the containers of a workload
+type migration struct {
+	regClient *registry.Client
+	// namespace of the backup destination, empty for the global one
+	destNamespace string
+	// the images are backed up but the workload is not updated
+	backupOnly bool
+	// source images by container name, kept to be able to roll back
+	originals map[string]string
+	// source images replaced by container name
+	changed map[string]string
+	// changes which are not done in backup only mode
+	report []string
+	// containers switched back to the upstream images
+	restored []string
+	// containers switched to the copy of their image in another backup registry
+	switched []string
+	// images moved from the previous backup registries
+	moved []string
+
+	numChangedImg, numPendingImg, numBackupImg, numFailedOverImg, numPreviousImg int
+	// the pull secret of the backup registry was added to the pod template
+	pullSecretAdded bool
+}
+
+// updated returns true if the workload's pod template was changed
+func (mig *migration) updated() bool {
+	return mig.numChangedImg > 0 || len(mig.restored) > 0 || len(mig.switched) > 0 || mig.pullSecretAdded
+}
+
+// failover returns true if the update replaces images the pods fail to pull
+func (mig *migration) failover(cfg *config.Config) bool {
+	return (cfg.FailoverMode && mig.numChangedImg > len(mig.moved)) || len(mig.switched) > 0
+}
+
+// Migrate replaces the workload's images with their backups.
+// Images which are not backed up yet get an ImageBackup,
+// the workload is reconciled again once the ImageBackup is done.
+func (m *Migrator) Migrate(logger logr.Logger, w *Workload) (reconcile.Result, error) {
+	cfg := config.Current()
+
+	backupOnly, skip := m.ownerGate(logger, w, cfg)
+	if skip {
+		return reconcile.Result{}, nil
+	}
+
+	// a previous migration may prevent the pods from starting
+	rolledBack, err := m.verifyRollout(logger, w, cfg)
+	if err != nil || rolledBack {
+		return reconcile.Result{}, err
+	}
+
+	mig, err := m.newMigration(logger, w, backupOnly)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := m.migrateContainers(logger, w, cfg, mig); err != nil {
+		return reconcile.Result{}, err
+	}
+	metrics.WorkloadPending(w.Kind, w.NamespacedName().String(), mig.numPendingImg > 0)
+	metrics.WorkloadOnPreviousRegistry(w.Kind, w.NamespacedName().String(), mig.numPreviousImg > 0)
+
+	if backupOnly {
+		m.reportBackupOnly(logger, w, mig.report)
+		return reconcile.Result{}, nil
+	}
+
+	if cfg.AtomicMigration && !cfg.FailoverMode && mig.numPendingImg > 0 {
+		// the workload is reconciled again once its images are backed up
+		logger.Info(fmt.Sprintf("Waiting for all the images to be backed up before updating the %s", w.Kind), "Pending images", mig.numPendingImg)
+		return reconcile.Result{}, nil
+	}
+
+	// backup images may need credentials to be pulled
+	if cfg.ManagePullSecrets && mig.numBackupImg > 0 {
+		if err := m.ensurePullSecret(w.Object.GetNamespace(), cfg.PullSecretName, mig.regClient); err != nil {
+			logger.Error(err, "Failed to maintain the pull secret", "Secret", cfg.PullSecretName)
+			m.recorder.Eventf(w.Object, corev1.EventTypeWarning, "PullSecretFailed", "Pull secret %s for the backup registry is not usable: %v", cfg.PullSecretName, err)
+			return reconcile.Result{}, err
+		}
+		mig.pullSecretAdded = addPullSecret(w.Template, cfg.PullSecretName)
+	}
+
+	// upstream images are checked again later
+	result := reconcile.Result{}
+	if mig.numFailedOverImg > 0 {
+		result.RequeueAfter = sourceCheckDelay
+	}
+
+	restart := w.RestartRequested()
+	if !mig.updated() && !restart {
+		if mig.numPendingImg == 0 {
+			logger.Info(fmt.Sprintf("%s is fully backed up!", w.Kind))
+		}
+		return result, nil
+	}
+	if admitted, deferred, err := m.admitUpdate(logger, w, cfg, mig); err != nil || !admitted {
+		return deferred, err
+	}
+	if err := m.update(logger, w, cfg, mig, restart); err != nil {
+		return reconcile.Result{}, err
+	}
+	return result, nil
+}
+
+// ownerGate checks whether the object controlling the workload may overwrite its update.
+// Returns whether the images are only backed up and whether the workload is skipped.
+func (m *Migrator) ownerGate(logger logr.Logger, w *Workload, cfg *config.Config) (bool, bool) {
+	owner := w.Owner()
+	if owner == nil || cfg.OwnerKindAllowed(owner.Kind) {
+		return false, false
+	}
+	if cfg.OwnedWorkloads == config.OwnedWorkloadsSkip {
+		logger.Info(fmt.Sprintf("Skipping the %s controlled by %s %s", w.Kind, owner.Kind, owner.Name))
+		metrics.WorkloadPending(w.Kind, w.NamespacedName().String(), false)
+		return false, true
+	}
+	return true, false
+}
+
+// newMigration returns the migration of the workload to the backup destination of its namespace if it has one, to the global one otherwise
+func (m *Migrator) newMigration(logger logr.Logger, w *Workload, backupOnly bool) (*migration, error) {
+	mig := &migration{
+		regClient:  m.regClient,
+		backupOnly: backupOnly,
+		originals:  originalImages(w),
+		changed:    map[string]string{},
+	}
+	nsClient, err := NamespaceRegistryClient(m.client, m.apiReader, w.Object.GetNamespace())
+	if err != nil {
+		logger.Error(err, "Failed to get the backup destination of the namespace")
+		m.recorder.Eventf(w.Object, corev1.EventTypeWarning, "InvalidBackupDestination", "Backup destination of the namespace is not usable: %v", err)
+		return nil, err
+	}
+	if nsClient != nil {
+		mig.regClient, mig.destNamespace = nsClient, w.Object.GetNamespace()
+	}
+	return mig, nil
+}
+
+// migrateContainers replaces the images of the workload's containers with their backups as far as allowed
+func (m *Migrator) migrateContainers(logger logr.Logger, w *Workload, cfg *config.Config, mig *migration) error {
+	// in failover mode only the images the pods fail to pull are replaced
+	var failing map[string]string
+	if cfg.FailoverMode {
+		var err error
+		if failing, err = m.failingPulls(w); err != nil {
+			return err
+		}
+	}
+
+	rules, excluded := m.imageRules(), rolledBackImages(w)
+	for i, c := range w.Template.Spec.Containers {
+		if mig.regClient.Belongs(c.Image) {
+			if err := m.checkBackupImage(logger, w, i, cfg, mig); err != nil {
+				return err
+			}
+			continue
+		}
+		// backup images of the previous backup registries are moved whatever the rules
+		previous := mig.regClient.Previous(c.Image)
+		if previous {
+			mig.numPreviousImg++
+		}
+		if excluded[c.Image] {
+			logger.Info("Skipping the image rolled back after pull failures", "Image", c.Image)
+			continue
+		}
+		if !previous && !m.policyGate(logger, w, c, rules) {
+			continue
+		}
+		if err := m.migrateImage(logger, w, i, previous, failing[c.Name], cfg, mig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBackupImage checks the i-th container, already using a backup image: it's switched back to its upstream image
+// once available again, or to the copy of its image the workloads should use
+func (m *Migrator) checkBackupImage(logger logr.Logger, w *Workload, i int, cfg *config.Config, mig *migration) error {
+	c := w.Template.Spec.Containers[i]
+	original, known := mig.originals[c.Name]
+	if cfg.FailoverSwitchBack && known {
+		if sourceAvailable(mig.regClient, original) {
+			logger.Info("Upstream image available again", "Image", original)
+			w.Template.Spec.Containers[i].Image = original
+			delete(mig.originals, c.Name)
+			mig.restored = append(mig.restored, c.Name)
+			return nil
+		}
+		mig.numFailedOverImg++
+	}
+	if known && !mig.backupOnly {
+		target, err := m.registryTarget(mig.regClient, original, mig.destNamespace)
+		if err != nil {
+			logger.Error(err, "Failed to get the image backup", "Image", original)
+			return err
+		}
+		if target != "" && target != c.Image {
+			logger.Info("Switching to the copy of the image in another backup registry", "Image", c.Image, "Target", target)
+			m.recorder.Eventf(w.Object, corev1.EventTypeNormal, "SwitchedRegistry", "Container %s switched from %s to %s", c.Name, c.Image, target)
+			w.Template.Spec.Containers[i].Image = target
+			mig.switched = append(mig.switched, c.Name)
+		}
+	}
+	mig.numBackupImg++
+	return nil
+}
+
+// policyGate returns true if the image rules allow to back up the image of the given container
+func (m *Migrator) policyGate(logger logr.Logger, w *Workload, c corev1.Container, rules *config.ImageRuleSet) bool {
+	action, rule := rules.Decide(c.Image)
+	if action == config.ImageActionBackup {
+		return true
+	}
+	logger.Info("Skipping the image by policy", "Image", c.Image, "Rule", rule)
+	metrics.ImageSkipped(string(action))
+	if action == config.ImageActionWarn {
+		m.recorder.Eventf(w.Object, corev1.EventTypeWarning, "ImageSkipped", "Image %s of container %s is not backed up by policy", c.Image, c.Name)
+	}
+	return false
+}
+
+// migrateImage replaces the image of the i-th container with its backup once backed up,
+// previous tells the image is a backup image of a previous backup registry and failing the image the pods fail to pull
+func (m *Migrator) migrateImage(logger logr.Logger, w *Workload, i int, previous bool, failing string, cfg *config.Config, mig *migration) error {
+	c := w.Template.Spec.Containers[i]
+	backup, err := m.imageBackup(c.Image, mig.destNamespace)
+	if err != nil {
+		logger.Error(err, "Failed to get the image backup", "Image", c.Image)
+		return err
+	}
+	target := mig.regClient.Target(c.Image, backup.ReplicaImages())
+	if !backup.BackedUp() || target == "" {
+		logger.Info("Waiting for the image to be backed up", "Image", c.Image, "ImageBackup", backup.Name)
+		mig.numPendingImg++
+		return nil
+	}
+	if cfg.FailoverMode && !previous && failing != c.Image {
+		// upstream image is fine
+		return nil
+	}
+	if mig.backupOnly {
+		mig.report = append(mig.report, fmt.Sprintf("%s: %s -> %s", c.Name, c.Image, target))
+		return nil
+	}
+
+	w.Template.Spec.Containers[i].Image = target
+	if _, known := mig.originals[c.Name]; !previous || !known {
+		mig.originals[c.Name] = c.Image
+	}
+	if previous {
+		mig.moved = append(mig.moved, fmt.Sprintf("%s: %s -> %s", c.Name, c.Image, target))
+	}
+	// moving from a previous registry is not failing over
+	if !previous || !cfg.FailoverMode {
+		mig.changed[c.Name] = c.Image
+	}
+	mig.numChangedImg++
+	mig.numBackupImg++
+	return nil
+}
+
+// reportBackupOnly reports the changes which are not done as the workload is controlled by another object
+func (m *Migrator) reportBackupOnly(logger logr.Logger, w *Workload, report []string) {
+	if len(report) == 0 {
+		return
+	}
+	owner := w.Owner()
+	logger.Info(fmt.Sprintf("Images backed up, not updating the %s controlled by %s %s", w.Kind, owner.Kind, owner.Name), "Changes", report)
+	m.recorder.Eventf(w.Object, corev1.EventTypeNormal, "BackupOnly", "Images backed up but not updated, the %s is controlled by %s %s: %s",
+		w.Kind, owner.Kind, owner.Name, strings.Join(report, ", "))
+}
+
+// admitUpdate passes the update of the workload through the conflict, maintenance window and pacing gates.
+// Returns false with the result of the reconciliation if the update is not done now.
+func (m *Migrator) admitUpdate(logger logr.Logger, w *Workload, cfg *config.Config, mig *migration) (bool, reconcile.Result, error) {
+	if inConflict, err := m.conflictGate(logger, w, cfg, mig.changed); err != nil || inConflict {
+		return false, reconcile.Result{}, err
+	}
+	// failing over can't wait: the pods don't start
+	if mig.failover(cfg) {
+		return true, reconcile.Result{}, nil
+	}
+	if delay, err := m.maintenanceGate(logger, w, cfg); err != nil || delay > 0 {
+		return false, reconcile.Result{RequeueAfter: delay}, err
+	}
+	if delay, err := m.pacingGate(logger, w); err != nil || delay > 0 {
+		return false, reconcile.Result{RequeueAfter: delay}, err
+	}
+	return true, reconcile.Result{}, nil
+}
+
+// conflictGate returns true if the workload is not updated anymore as another tool keeps reverting its updates
+func (m *Migrator) conflictGate(logger logr.Logger, w *Workload, cfg *config.Config, changed map[string]string) (bool, error) {
+	// switching back and forth is not a conflict
+	if m.conflicts == nil || cfg.FailoverMode {
+		return false, nil
+	}
+	inConflict, detected := m.conflicts.Check(w, changed)
+	if detected {
+		logger.Info(fmt.Sprintf("Updates of the %s keep being reverted, not updating it anymore", w.Kind))
+		if err := m.saveUpdateHistory(w); err != nil {
+			return true, err
+		}
+		m.recorder.Eventf(w.Object, corev1.EventTypeWarning, "UpdateConflict",
+			"Image updates keep being reverted by another tool (e.g. GitOps or an operator), the %s is not updated anymore, remove the %s annotation to update it again",
+			w.Kind, UpdateHistoryAnnotation)
+	}
+	return inConflict, nil
+}
+
+// maintenanceGate returns 0 if the workload can be updated now, the delay until the next maintenance window otherwise
+func (m *Migrator) maintenanceGate(logger logr.Logger, w *Workload, cfg *config.Config) (time.Duration, error) {
+	delay, err := m.maintenanceDelay(logger, w, cfg)
+	if err != nil || delay == 0 {
+		return 0, err
+	}
+	logger.Info(fmt.Sprintf("Deferring the update of the %s until the next maintenance window", w.Kind), "Delay", delay)
+	metrics.WorkloadDeferred(w.Kind, metrics.ReasonMaintenanceWindow)
+	return delay, nil
+}
+
+// pacingGate returns 0 if the workload can be updated now, the delay before updating it within the pacing limits otherwise
+func (m *Migrator) pacingGate(logger logr.Logger, w *Workload) (time.Duration, error) {
+	if m.pacer == nil {
+		return 0, nil
+	}
+	delay, err := m.pacer.Admit(w)
+	if err != nil || delay == 0 {
+		return 0, err
+	}
+	logger.Info(fmt.Sprintf("Deferring the update of the %s", w.Kind), "Delay", delay)
+	metrics.WorkloadDeferred(w.Kind, metrics.ReasonPacing)
+	return delay, nil
+}
+
+// update writes the migrated workload, restarting its pods if requested
+func (m *Migrator) update(logger logr.Logger, w *Workload, cfg *config.Config, mig *migration, restart bool) error {
+	// the original images are kept to be able to roll back
+	if err := setOriginalImages(w, mig.originals); err != nil {
+		return err
+	}
+	if m.conflicts != nil && mig.numChangedImg > 0 && !cfg.FailoverMode {
+		m.conflicts.Migrated(w, mig.changed)
+	}
+	// the restart requested when a backup image was refreshed
+	if restart {
+		w.Restart(now())
+	}
+	logger.Info(fmt.Sprintf("Updating the %s to backed up images", w.Kind), "Changed images", mig.numChangedImg, "Restored images", len(mig.restored),
+		"Switched images", len(mig.switched), "Restarted", restart)
+	if err := m.client.Update(context.Background(), w.Object); err != nil {
+		if m.pacer != nil {
+			m.pacer.Release(w)
+		}
+		return err
+	}
+	if mig.numChangedImg > 0 || mig.pullSecretAdded {
+		metrics.WorkloadMigrated(w.Kind)
+	}
+	m.failoverEvents(w, mig.changed, mig.restored, cfg)
+	if len(mig.moved) > 0 {
+		metrics.WorkloadOnPreviousRegistry(w.Kind, w.NamespacedName().String(), mig.numPreviousImg > len(mig.moved))
+		m.recorder.Eventf(w.Object, corev1.EventTypeNormal, "RegistryMigrated", "Images moved from the previous backup registry: %s", strings.Join(mig.moved, ", "))
+	}
+	return nil
+}
+
+// Forget clears the state kept for a deleted workload
+func (m *Migrator) Forget(kind string, name types.NamespacedName) {
+	metrics.WorkloadPending(kind, name.String(), false)
+	metrics.WorkloadOnPreviousRegistry(kind, name.String(), false)
+	if m.conflicts != nil {
+		m.conflicts.Forget(kind, name.String())
+	}
+}
+
+// registryTarget returns the backup image of the given original image the workloads should use when it's backed up
+// to several registries, empty if it's backed up to a single one
+func (m *Migrator) registryTarget(regClient *registry.Client, original, namespace string) (string, error) {
+	if len(regClient.Destinations(original)) < 2 {
+		return "", nil
+	}
+	backup, err := m.imageBackup(original, namespace)
+	if err != nil {
+		return "", err
+	}
+	if !backup.BackedUp() {
+		return "", nil
+	}
+	return regClient.Target(original, backup.ReplicaImages()), nil
+}
+
+// imageBackup returns the ImageBackup of the given image to the BackupDestination of the given namespace,
+// to the global backup registry if the namespace is empty. The ImageBackup is created if it doesn't exist yet.
+func (m *Migrator) imageBackup(image, namespace string) (*v1alpha1.ImageBackup, error) {
+	name := v1alpha1.NamespaceImageBackupName(namespace, image)
+	backup := &v1alpha1.ImageBackup{}
+	err := m.client.Get(context.Background(), types.NamespacedName{Name: name}, backup)
+	if errors.IsNotFound(err) {
+		backup = &v1alpha1.ImageBackup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Spec: v1alpha1.ImageBackupSpec{
+				Source:    image,
+				Namespace: namespace,
+			},
+		}
+		err = m.client.Create(context.Background(), backup)
+		if errors.IsAlreadyExists(err) {
+			// created by another workload in the meantime, the cache will catch up
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if backup.Spec.Source != image || backup.Spec.Namespace != namespace {
+		return nil, fmt.Errorf("image backup %s is for %s in namespace %q", name, backup.Spec.Source, backup.Spec.Namespace)
+	}
+	return backup, nil
+}
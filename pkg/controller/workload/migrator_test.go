@@ -0,0 +1,576 @@
+package workload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/registry"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestMigrate(t *testing.T) {
+	// at noon for the maintenance windows
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return time.Date(2020, 3, 4, 12, 30, 0, 0, time.UTC) }
+
+	syncTime := metav1.Now()
+	backedUp := &v1alpha1.ImageBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.ImageBackupName("nginx")},
+		Spec:       v1alpha1.ImageBackupSpec{Source: "nginx"},
+		Status: v1alpha1.ImageBackupStatus{
+			Source:       "nginx",
+			Destination:  "quay.io/org/nginx",
+			LastSyncTime: &syncTime,
+		},
+	}
+	otherDestination := backedUp.DeepCopy()
+	otherDestination.Status.Destination = "quay.io/old-org/nginx"
+	notBackedUp := &v1alpha1.ImageBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.ImageBackupName("redis")},
+		Spec:       v1alpha1.ImageBackupSpec{Source: "redis"},
+		Status: v1alpha1.ImageBackupStatus{
+			CopyAttempts: 1,
+			LastError:    "timeout",
+		},
+	}
+
+	destination := &v1alpha1.BackupDestination{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: v1alpha1.DefaultBackupDestinationName},
+		Spec:       v1alpha1.BackupDestinationSpec{Registry: "quay.io", Organization: "tenant", CredentialsSecret: "creds"},
+	}
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "creds"},
+		Data:       map[string][]byte{"username": []byte("user"), "password": []byte("pwd")},
+	}
+	tenantBackedUp := &v1alpha1.ImageBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.NamespaceImageBackupName("ns", "nginx")},
+		Spec:       v1alpha1.ImageBackupSpec{Source: "nginx", Namespace: "ns"},
+		Status: v1alpha1.ImageBackupStatus{
+			Source:       "nginx",
+			Destination:  "quay.io/tenant/nginx",
+			LastSyncTime: &syncTime,
+		},
+	}
+
+	testCases := []struct {
+		name              string
+		images            []string
+		rules             []config.ImageRule
+		backups           []runtime.Object
+		expectedImages    []string
+		expectedBackups   []string
+		expectedNamespace string
+		expectedError     bool
+		atomic            bool
+		windows           []string
+		nsAnnotations     map[string]string
+		expectedRequeue   bool
+		owner             string
+		ownedWorkloads    string
+		allowedOwners     []string
+	}{
+		{
+			name:            "New image",
+			images:          []string{"nginx"},
+			expectedImages:  []string{"nginx"},
+			expectedBackups: []string{"nginx"},
+		},
+		{
+			name:            "Backed up image",
+			images:          []string{"nginx"},
+			backups:         []runtime.Object{backedUp},
+			expectedImages:  []string{"quay.io/org/nginx"},
+			expectedBackups: []string{"nginx"},
+		},
+		{
+			name:            "Backed up to another destination",
+			images:          []string{"nginx"},
+			backups:         []runtime.Object{otherDestination},
+			expectedImages:  []string{"nginx"},
+			expectedBackups: []string{"nginx"},
+		},
+		{
+			name:            "Partially backed up",
+			images:          []string{"nginx", "redis"},
+			backups:         []runtime.Object{backedUp, notBackedUp},
+			expectedImages:  []string{"quay.io/org/nginx", "redis"},
+			expectedBackups: []string{"nginx", "redis"},
+		},
+		{
+			name:            "Atomic migration partially backed up",
+			images:          []string{"nginx", "redis"},
+			backups:         []runtime.Object{backedUp, notBackedUp},
+			atomic:          true,
+			expectedImages:  []string{"nginx", "redis"},
+			expectedBackups: []string{"nginx", "redis"},
+		},
+		{
+			name:            "Atomic migration fully backed up",
+			images:          []string{"nginx"},
+			backups:         []runtime.Object{backedUp},
+			atomic:          true,
+			expectedImages:  []string{"quay.io/org/nginx"},
+			expectedBackups: []string{"nginx"},
+		},
+		{
+			name:            "Out of the maintenance windows",
+			images:          []string{"nginx"},
+			backups:         []runtime.Object{backedUp},
+			windows:         []string{"0 2 * * * 3h"},
+			expectedImages:  []string{"nginx"},
+			expectedBackups: []string{"nginx"},
+			expectedRequeue: true,
+		},
+		{
+			name:            "In the namespace maintenance windows",
+			images:          []string{"nginx"},
+			backups:         []runtime.Object{backedUp},
+			windows:         []string{"0 2 * * * 3h"},
+			nsAnnotations:   map[string]string{MaintenanceWindowsAnnotation: "0 0 1 1 * 1h; 0 12 * * * 1h"},
+			expectedImages:  []string{"quay.io/org/nginx"},
+			expectedBackups: []string{"nginx"},
+		},
+		{
+			name:            "Invalid namespace maintenance windows",
+			images:          []string{"nginx"},
+			backups:         []runtime.Object{backedUp},
+			nsAnnotations:   map[string]string{MaintenanceWindowsAnnotation: "0 12 * * *"},
+			expectedImages:  []string{"nginx"},
+			expectedBackups: []string{"nginx"},
+			expectedError:   true,
+		},
+		{
+			name:           "Already in the backup registry",
+			images:         []string{"quay.io/org/nginx"},
+			expectedImages: []string{"quay.io/org/nginx"},
+		},
+		{
+			name:            "Skipped by policy",
+			images:          []string{"nginx", "nvidia/cuda"},
+			rules:           []config.ImageRule{{Repository: "nvidia/*", Action: config.ImageActionWarn}},
+			backups:         []runtime.Object{backedUp},
+			expectedImages:  []string{"quay.io/org/nginx", "nvidia/cuda"},
+			expectedBackups: []string{"nginx"},
+		},
+		{
+			name:            "Owned workload",
+			images:          []string{"nginx"},
+			backups:         []runtime.Object{backedUp},
+			owner:           "Operator",
+			expectedImages:  []string{"nginx"},
+			expectedBackups: []string{"nginx"},
+		},
+		{
+			name:            "Owned workload backed up only",
+			images:          []string{"nginx", "redis"},
+			backups:         []runtime.Object{backedUp},
+			owner:           "Operator",
+			ownedWorkloads:  config.OwnedWorkloadsBackupOnly,
+			expectedImages:  []string{"nginx", "redis"},
+			expectedBackups: []string{"nginx", "redis"},
+		},
+		{
+			name:            "Owner kind allowed",
+			images:          []string{"nginx"},
+			backups:         []runtime.Object{backedUp},
+			owner:           "HelmRelease",
+			allowedOwners:   []string{"HelmRelease"},
+			expectedImages:  []string{"quay.io/org/nginx"},
+			expectedBackups: []string{"nginx"},
+		},
+		{
+			name:              "Namespace destination",
+			images:            []string{"nginx"},
+			backups:           []runtime.Object{destination, credentials},
+			expectedImages:    []string{"nginx"},
+			expectedBackups:   []string{"nginx"},
+			expectedNamespace: "ns",
+		},
+		{
+			name:              "Backed up to namespace destination",
+			images:            []string{"nginx", "quay.io/tenant/redis"},
+			backups:           []runtime.Object{tenantBackedUp, destination, credentials},
+			expectedImages:    []string{"quay.io/tenant/nginx", "quay.io/tenant/redis"},
+			expectedBackups:   []string{"nginx"},
+			expectedNamespace: "ns",
+		},
+		{
+			name:           "Namespace destination without credentials",
+			images:         []string{"nginx"},
+			backups:        []runtime.Object{destination},
+			expectedImages: []string{"nginx"},
+			expectedError:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			applyTestConfig(t, func(c *config.Config) {
+				c.AtomicMigration = tc.atomic
+				c.MaintenanceWindows = tc.windows
+				c.OwnedWorkloads = tc.ownedWorkloads
+				c.AllowedOwnerKinds = tc.allowedOwners
+			})
+			defer applyTestConfig(t, func(c *config.Config) {})
+
+			deploy := newTestDeployment("ns", "app", tc.images...)
+			if tc.owner != "" {
+				controller := true
+				deploy.OwnerReferences = []metav1.OwnerReference{{Kind: tc.owner, Name: "owner", Controller: &controller}}
+			}
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Annotations: tc.nsAnnotations}}
+			cli := fake.NewFakeClientWithScheme(newTestScheme(t), append(tc.backups, deploy, ns)...)
+			rules, err := config.NewImageRuleSet(tc.rules)
+			if err != nil {
+				t.Fatalf("Failed to create the rules: %v", err)
+			}
+			m := &Migrator{
+				client:    cli,
+				apiReader: cli,
+				regClient: registry.NewClient("quay.io", "org", "", "", 0),
+				rules:     config.Fixed(rules),
+				recorder:  record.NewFakeRecorder(10),
+			}
+
+			result, err := m.Migrate(logf.Log, FromObject(deploy))
+			if tc.expectedError != (err != nil) {
+				t.Fatalf("Expected error: %v, got: %v", tc.expectedError, err)
+			}
+			if tc.expectedRequeue != (result.RequeueAfter > 0) {
+				t.Errorf("Expected requeue: %v, got: %v", tc.expectedRequeue, result.RequeueAfter)
+			}
+
+			output := &appsv1.Deployment{}
+			if err := cli.Get(context.Background(), types.NamespacedName{Namespace: "ns", Name: "app"}, output); err != nil {
+				t.Fatalf("Failed to get the deployment: %v", err)
+			}
+			outputImages := FromObject(output).Images()
+			if len(outputImages) != len(tc.expectedImages) {
+				t.Fatalf("Expected images %v, got %v", tc.expectedImages, outputImages)
+			}
+			originals := originalImages(FromObject(output))
+			for i := range outputImages {
+				if outputImages[i] != tc.expectedImages[i] {
+					t.Errorf("Expected images %v, got %v", tc.expectedImages, outputImages)
+				}
+				if name := output.Spec.Template.Spec.Containers[i].Name; outputImages[i] != tc.images[i] && originals[name] != tc.images[i] {
+					t.Errorf("Expected original image %q for container %s, got %q", tc.images[i], name, originals[name])
+				}
+			}
+
+			backups := &v1alpha1.ImageBackupList{}
+			if err := cli.List(context.Background(), backups); err != nil {
+				t.Fatalf("Failed to list the image backups: %v", err)
+			}
+			if len(backups.Items) != len(tc.expectedBackups) {
+				t.Fatalf("Expected %d image backups, got %d", len(tc.expectedBackups), len(backups.Items))
+			}
+			for _, src := range tc.expectedBackups {
+				b := &v1alpha1.ImageBackup{}
+				if err := cli.Get(context.Background(), client.ObjectKey{Name: v1alpha1.NamespaceImageBackupName(tc.expectedNamespace, src)}, b); err != nil {
+					t.Errorf("Expected image backup for %q: %v", src, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateBackupRegistries(t *testing.T) {
+	syncTime := metav1.Now()
+	// both registries are unreachable: the copy in the registry with the lowest priority is used
+	primary, secondary := "127.0.0.1:2/org/nginx", "127.0.0.1:1/backup/nginx"
+
+	testCases := []struct {
+		name          string
+		image         string
+		originals     string
+		replicas      []string
+		expectedImage string
+	}{
+		{
+			name:          "Copied to all the registries",
+			image:         "nginx",
+			replicas:      []string{primary, secondary},
+			expectedImage: primary,
+		},
+		{
+			name:          "Copied to the secondary registry only",
+			image:         "nginx",
+			replicas:      []string{secondary},
+			expectedImage: secondary,
+		},
+		{
+			name:          "Switched back to the primary registry",
+			image:         secondary,
+			originals:     `{"a":"nginx"}`,
+			replicas:      []string{primary, secondary},
+			expectedImage: primary,
+		},
+		{
+			name:          "Original image unknown",
+			image:         secondary,
+			replicas:      []string{primary, secondary},
+			expectedImage: secondary,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			applyTestConfig(t, func(c *config.Config) {
+				c.Registry = "127.0.0.1:2"
+				c.BackupRegistries = []config.BackupRegistry{{Registry: "127.0.0.1:1", Organization: "backup", Username: "user", Password: "pwd", Priority: 1}}
+			})
+			defer applyTestConfig(t, func(c *config.Config) {})
+
+			backup := &v1alpha1.ImageBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.ImageBackupName("nginx")},
+				Spec:       v1alpha1.ImageBackupSpec{Source: "nginx"},
+				Status: v1alpha1.ImageBackupStatus{
+					Source:       "nginx",
+					Destination:  tc.replicas[0],
+					Replicas:     tc.replicas,
+					LastSyncTime: &syncTime,
+				},
+			}
+			deploy := newTestDeployment("ns", "app", tc.image)
+			if tc.originals != "" {
+				deploy.Annotations = map[string]string{OriginalImagesAnnotation: tc.originals}
+			}
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}}
+			cli := fake.NewFakeClientWithScheme(newTestScheme(t), backup, deploy, ns)
+			m := &Migrator{
+				client:    cli,
+				apiReader: cli,
+				regClient: registry.NewClientFromConfig(),
+				rules:     config.Fixed(&config.ImageRuleSet{}),
+				recorder:  record.NewFakeRecorder(10),
+			}
+
+			if _, err := m.Migrate(logf.Log, FromObject(deploy)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			output := &appsv1.Deployment{}
+			if err := cli.Get(context.Background(), types.NamespacedName{Namespace: "ns", Name: "app"}, output); err != nil {
+				t.Fatalf("Failed to get the deployment: %v", err)
+			}
+			if image := output.Spec.Template.Spec.Containers[0].Image; image != tc.expectedImage {
+				t.Errorf("Expected image %q, got %q", tc.expectedImage, image)
+			}
+		})
+	}
+}
+
+func TestMigratePreviousRegistry(t *testing.T) {
+	syncTime := metav1.Now()
+	previous := "docker.io/old/quay.io-coreos-etcd:v3"
+	backedUp := &v1alpha1.ImageBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.ImageBackupName(previous)},
+		Spec:       v1alpha1.ImageBackupSpec{Source: previous},
+		Status: v1alpha1.ImageBackupStatus{
+			Source:       previous,
+			Destination:  "quay.io/org/quay.io-coreos-etcd:v3",
+			LastSyncTime: &syncTime,
+		},
+	}
+
+	testCases := []struct {
+		name              string
+		backups           []runtime.Object
+		originals         string
+		expectedImage     string
+		expectedOriginals string
+	}{
+		{
+			name:              "Backed up",
+			backups:           []runtime.Object{backedUp.DeepCopy()},
+			expectedImage:     "quay.io/org/quay.io-coreos-etcd:v3",
+			expectedOriginals: `{"a":"docker.io/old/quay.io-coreos-etcd:v3"}`,
+		},
+		{
+			name:              "Original image known",
+			backups:           []runtime.Object{backedUp.DeepCopy()},
+			originals:         `{"a":"quay.io/coreos/etcd:v3"}`,
+			expectedImage:     "quay.io/org/quay.io-coreos-etcd:v3",
+			expectedOriginals: `{"a":"quay.io/coreos/etcd:v3"}`,
+		},
+		{
+			name:          "Not backed up yet",
+			expectedImage: previous,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			applyTestConfig(t, func(c *config.Config) {
+				c.MigrateFrom = []string{"docker.io/old"}
+			})
+			defer applyTestConfig(t, func(c *config.Config) {})
+
+			deploy := newTestDeployment("ns", "app", previous)
+			if tc.originals != "" {
+				deploy.Annotations = map[string]string{OriginalImagesAnnotation: tc.originals}
+			}
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}}
+			cli := fake.NewFakeClientWithScheme(newTestScheme(t), append(tc.backups, deploy, ns)...)
+			// the images of the previous registry are moved whatever the rules
+			rules, err := config.NewImageRuleSet([]config.ImageRule{{Registry: "docker.io", Action: config.ImageActionSkip}})
+			if err != nil {
+				t.Fatalf("Failed to create the rules: %v", err)
+			}
+			m := &Migrator{
+				client:    cli,
+				apiReader: cli,
+				regClient: registry.NewClientFromConfig(),
+				rules:     config.Fixed(rules),
+				recorder:  record.NewFakeRecorder(10),
+			}
+
+			if _, err := m.Migrate(logf.Log, FromObject(deploy)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			output := &appsv1.Deployment{}
+			if err := cli.Get(context.Background(), types.NamespacedName{Namespace: "ns", Name: "app"}, output); err != nil {
+				t.Fatalf("Failed to get the deployment: %v", err)
+			}
+			if image := output.Spec.Template.Spec.Containers[0].Image; image != tc.expectedImage {
+				t.Errorf("Expected image %q, got %q", tc.expectedImage, image)
+			}
+			if originals := output.Annotations[OriginalImagesAnnotation]; tc.expectedOriginals != "" && originals != tc.expectedOriginals {
+				t.Errorf("Expected original images %q, got %q", tc.expectedOriginals, originals)
+			}
+			if err := cli.Get(context.Background(), client.ObjectKey{Name: v1alpha1.ImageBackupName(previous)}, &v1alpha1.ImageBackup{}); err != nil {
+				t.Errorf("Expected image backup for %q: %v", previous, err)
+			}
+		})
+	}
+}
+
+// applyTestConfig puts a valid configuration modified by the given function in effect
+func TestMigrateRestartRequested(t *testing.T) {
+	// at noon for the maintenance windows
+	defer func(clock func() time.Time) { now = clock }(now)
+	now = func() time.Time { return time.Date(2020, 3, 4, 12, 30, 0, 0, time.UTC) }
+
+	testCases := []struct {
+		name              string
+		windows           []string
+		expectedRestarted bool
+	}{
+		{
+			name:              "No maintenance windows",
+			expectedRestarted: true,
+		},
+		{
+			name:    "Outside the maintenance windows",
+			windows: []string{"0 0 1 1 * 1h"},
+		},
+		{
+			name:              "Within a maintenance window",
+			windows:           []string{"0 12 * * * 1h"},
+			expectedRestarted: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			applyTestConfig(t, func(c *config.Config) {
+				c.MaintenanceWindows = tc.windows
+			})
+			defer applyTestConfig(t, func(c *config.Config) {})
+
+			// a backup image refreshed by the tag re-sync
+			deploy := newTestDeployment("ns", "app", "quay.io/org/nginx")
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}}
+			cli := fake.NewFakeClientWithScheme(newTestScheme(t), deploy, ns)
+			if err := FromObject(deploy).RequestRestart(cli, now()); err != nil {
+				t.Fatalf("Failed to request the restart: %v", err)
+			}
+			ref := v1alpha1.WorkloadReference{Kind: KindDeployment, Namespace: "ns", Name: "app"}
+			w, err := Get(cli, ref)
+			if err != nil {
+				t.Fatalf("Failed to get the workload: %v", err)
+			}
+
+			m := &Migrator{
+				client:    cli,
+				apiReader: cli,
+				regClient: registry.NewClient("quay.io", "org", "", "", 0),
+				rules:     config.Fixed(&config.ImageRuleSet{}),
+				recorder:  record.NewFakeRecorder(10),
+				pacer:     NewPacer(cli, 0, 0),
+			}
+			result, err := m.Migrate(logf.Log, w)
+			if err != nil {
+				t.Fatalf("Got not expected error: %v", err)
+			}
+
+			output, err := Get(cli, ref)
+			if err != nil {
+				t.Fatalf("Failed to get the workload: %v", err)
+			}
+			_, restarted := output.Template.Annotations[RestartedAtAnnotation]
+			if restarted != tc.expectedRestarted {
+				t.Errorf("Expected restarted: %v, got: %v", tc.expectedRestarted, restarted)
+			}
+			if output.RestartRequested() == tc.expectedRestarted {
+				t.Errorf("Expected the request to be kept until the restart")
+			}
+			if deferred := result.RequeueAfter > 0; deferred == tc.expectedRestarted {
+				t.Errorf("Expected the deferred restart to be retried, got %v", result)
+			}
+		})
+	}
+}
+
+func applyTestConfig(t *testing.T, modify func(c *config.Config)) {
+	c := &config.Config{
+		Registry:     "quay.io",
+		Organization: "org",
+		Username:     "user",
+		Password:     "pwd",
+	}
+	modify(c)
+	if err := config.Apply(c); err != nil {
+		t.Fatalf("Failed to apply the config: %v", err)
+	}
+}
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		t.Fatalf("Failed to build the scheme: %v", err)
+	}
+	if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+		t.Fatalf("Failed to build the scheme: %v", err)
+	}
+	return s
+}
+
+func newTestDeployment(ns, name string, images ...string) *appsv1.Deployment {
+	d := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ns,
+			Name:      name,
+		},
+	}
+	for i, image := range images {
+		d.Spec.Template.Spec.Containers = append(d.Spec.Template.Spec.Containers, corev1.Container{
+			Name:  string(rune('a' + i)),
+			Image: image,
+		})
+	}
+	return d
+}
@@ -0,0 +1,181 @@
+package workload
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"image-clone-controller/pkg/config"
+
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// delay before checking again the rollouts of a namespace
+	rolloutCheckDelay = 30 * time.Second
+	// an admitted update not seen rolled out in the cache stops counting as a rollout after this delay,
+	// the default progress deadline of the deployments
+	admissionTimeout = 10 * time.Minute
+)
+
+var (
+	// the pace is shared by all the workload controllers
+	sharedPacer     *Pacer
+	sharedPacerOnce sync.Once
+)
+
+// Pacer limits the pace of the workload updates: globally and by the number of the concurrent rollouts in a namespace
+type Pacer struct {
+	client client.Reader
+
+	// pace limits (paceLimits)
+	limits *config.Derived
+
+	lock    sync.Mutex
+	limiter *rate.Limiter
+	// number of updates per minute the limiter was built for
+	limiterRate int
+	// admitted updates by workload: the cache may not show their rollouts yet
+	admitted map[string]admission
+}
+
+// admission is an update admitted by the pacer, counted as a rollout until the cache shows it rolled out
+type admission struct {
+	// generation of the workload before the update
+	generation int64
+	time       time.Time
+}
+
+// paceLimits are the limits of the pace of the workload updates, 0 means no limit
+type paceLimits struct {
+	updatesPerMinute int
+	maxConcurrent    int
+}
+
+// newPaceLimits returns the limits set in the given config
+func newPaceLimits(c *config.Config) interface{} {
+	return paceLimits{updatesPerMinute: c.MaxUpdatesPerMinute, maxConcurrent: c.MaxConcurrentRollouts}
+}
+
+// NewPacer returns a pacer allowing the given number of updates per minute
+// and concurrent rollouts per namespace, 0 means no limit
+func NewPacer(c client.Reader, updatesPerMinute, maxConcurrent int) *Pacer {
+	return &Pacer{
+		client:      c,
+		limits:      config.Fixed(paceLimits{updatesPerMinute: updatesPerMinute, maxConcurrent: maxConcurrent}),
+		limiter:     newLimiter(updatesPerMinute),
+		limiterRate: updatesPerMinute,
+		admitted:    map[string]admission{},
+	}
+}
+
+// NewPacerFromConfig returns a pacer set from the program's config, the pacer follows the configuration changes
+func NewPacerFromConfig(c client.Reader) *Pacer {
+	cfg := config.Current()
+	p := NewPacer(c, cfg.MaxUpdatesPerMinute, cfg.MaxConcurrentRollouts)
+	p.limits = config.NewDerived(newPaceLimits)
+	return p
+}
+
+// sharedPacerFromConfig returns the pacer shared by all the workload controllers, set from the program's config
+func sharedPacerFromConfig(c client.Reader) *Pacer {
+	sharedPacerOnce.Do(func() {
+		sharedPacer = NewPacerFromConfig(c)
+	})
+	return sharedPacer
+}
+
+// newLimiter returns a limiter for the given number of events per minute, nil if not limited
+func newLimiter(perMinute int) *rate.Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(float64(perMinute)/60), 1)
+}
+
+// pace returns the update limiter and the maximum number of concurrent rollouts, updated if the program's config changed.
+// Must be called with the lock held.
+func (p *Pacer) pace() (*rate.Limiter, int) {
+	limits := p.limits.Get().(paceLimits)
+	// the budget is kept as long as the rate doesn't change
+	if limits.updatesPerMinute != p.limiterRate {
+		p.limiter, p.limiterRate = newLimiter(limits.updatesPerMinute), limits.updatesPerMinute
+	}
+	return p.limiter, limits.maxConcurrent
+}
+
+// Admit returns 0 if the workload can be updated now, the delay before trying again otherwise.
+// An admitted update consumes the global budget and counts as a rollout of the namespace
+// until the cache shows the workload rolled out.
+func (p *Pacer) Admit(w *Workload) (time.Duration, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	limiter, maxConcurrent := p.pace()
+
+	if maxConcurrent > 0 {
+		workloads, err := ListAll(p.client, w.Object.GetNamespace())
+		if err != nil {
+			return 0, err
+		}
+		if p.rollouts(w, workloads) >= maxConcurrent {
+			return rolloutCheckDelay, nil
+		}
+	}
+
+	if limiter != nil {
+		r := limiter.Reserve()
+		if delay := r.Delay(); delay > 0 {
+			// the update is not done now: giving the budget back
+			r.Cancel()
+			return delay, nil
+		}
+	}
+	if maxConcurrent > 0 {
+		p.admitted[workloadKey(w)] = admission{generation: w.Object.GetGeneration(), time: now()}
+	}
+	return 0, nil
+}
+
+// Release gives back the rollout counted for an admitted update which failed
+func (p *Pacer) Release(w *Workload) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.admitted, workloadKey(w))
+}
+
+// rollouts returns the number of the rollouts of the given workloads of a namespace, the given workload excluded:
+// the ones rolling out in the cache and the admitted updates not seen rolled out yet.
+// Must be called with the lock held.
+func (p *Pacer) rollouts(w *Workload, workloads []*Workload) int {
+	listed := map[string]bool{}
+	rollouts := 0
+	for _, o := range workloads {
+		key := workloadKey(o)
+		listed[key] = true
+		if key == workloadKey(w) {
+			continue
+		}
+		a, admitted := p.admitted[key]
+		// rolled out: the cache shows the updated generation fully rolled out
+		if admitted && ((o.Object.GetGeneration() > a.generation && !o.RollingOut()) || now().Sub(a.time) > admissionTimeout) {
+			delete(p.admitted, key)
+			admitted = false
+		}
+		if admitted || o.RollingOut() {
+			rollouts++
+		}
+	}
+	// deleted workloads
+	for key := range p.admitted {
+		if !listed[key] && strings.HasPrefix(key, w.Object.GetNamespace()+"/") {
+			delete(p.admitted, key)
+		}
+	}
+	return rollouts
+}
+
+// workloadKey returns the key of the workload in the pacer: namespace/kind/name
+func workloadKey(w *Workload) string {
+	return w.Object.GetNamespace() + "/" + w.Kind + "/" + w.Object.GetName()
+}
@@ -0,0 +1,188 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPacerConcurrentRollouts(t *testing.T) {
+	testCases := []struct {
+		name          string
+		existing      []runtime.Object
+		maxConcurrent int
+		expectedDefer bool
+	}{
+		{
+			name:          "No limit",
+			existing:      []runtime.Object{rollingOut(newTestDeployment("ns", "one", "nginx"))},
+			maxConcurrent: 0,
+		},
+		{
+			name:          "Under the limit",
+			existing:      []runtime.Object{rollingOut(newTestDeployment("ns", "one", "nginx")), rolledOut(newTestDeployment("ns", "two", "nginx"))},
+			maxConcurrent: 2,
+		},
+		{
+			name:          "Limit reached",
+			existing:      []runtime.Object{rollingOut(newTestDeployment("ns", "one", "nginx")), rollingOut(newTestDeployment("ns", "two", "nginx"))},
+			maxConcurrent: 2,
+			expectedDefer: true,
+		},
+		{
+			name:          "Rollouts in other namespaces",
+			existing:      []runtime.Object{rollingOut(newTestDeployment("other", "one", "nginx"))},
+			maxConcurrent: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			deploy := rollingOut(newTestDeployment("ns", "app", "nginx"))
+			cli := fake.NewFakeClientWithScheme(newTestScheme(t), append(tc.existing, deploy)...)
+			p := NewPacer(cli, 0, tc.maxConcurrent)
+
+			delay, err := p.Admit(FromObject(deploy))
+			if err != nil {
+				t.Fatalf("Got not expected error: %v", err)
+			}
+			if tc.expectedDefer != (delay > 0) {
+				t.Errorf("Expected deferred: %v, got delay %v", tc.expectedDefer, delay)
+			}
+		})
+	}
+}
+
+func TestPacerStaleCache(t *testing.T) {
+	defer func(clock func() time.Time) { now = clock }(now)
+	start := time.Date(2020, 3, 4, 12, 30, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+
+	// the cache doesn't show the rollouts of the admitted updates yet
+	maxConcurrent := 2
+	existing := []runtime.Object{}
+	for i := 0; i <= maxConcurrent; i++ {
+		existing = append(existing, rolledOut(newTestDeployment("ns", fmt.Sprintf("app-%d", i), "nginx")))
+	}
+	cli := fake.NewFakeClientWithScheme(newTestScheme(t), existing...)
+	p := NewPacer(cli, 0, maxConcurrent)
+
+	for i, o := range existing {
+		delay, err := p.Admit(FromObject(o))
+		if err != nil {
+			t.Fatalf("Got not expected error: %v", err)
+		}
+		if deferred := i == maxConcurrent; deferred != (delay > 0) {
+			t.Errorf("Expected update %d deferred: %v, got delay %v", i, deferred, delay)
+		}
+	}
+
+	// the first update is seen rolled out
+	updated := existing[0].(*appsv1.Deployment).DeepCopy()
+	updated.Generation, updated.Status.ObservedGeneration = 1, 1
+	if err := cli.Update(context.Background(), updated); err != nil {
+		t.Fatalf("Failed to update the deployment: %v", err)
+	}
+	if delay, _ := p.Admit(FromObject(existing[maxConcurrent])); delay != 0 {
+		t.Errorf("Expected the update to be admitted once a rollout finished, got delay %v", delay)
+	}
+
+	// the rollouts never seen in the cache stop counting after the timeout
+	next := rolledOut(newTestDeployment("ns", "next", "nginx"))
+	if err := cli.Create(context.Background(), next); err != nil {
+		t.Fatalf("Failed to create the deployment: %v", err)
+	}
+	if delay, _ := p.Admit(FromObject(next)); delay == 0 {
+		t.Errorf("Expected the update to be deferred while the admitted updates are not seen rolled out")
+	}
+	now = func() time.Time { return start.Add(admissionTimeout + time.Second) }
+	if delay, _ := p.Admit(FromObject(next)); delay != 0 {
+		t.Errorf("Expected the update to be admitted after the timeout, got delay %v", delay)
+	}
+}
+
+func TestPacerUpdatesPerMinute(t *testing.T) {
+	deploy := newTestDeployment("ns", "app", "nginx")
+	p := NewPacer(fake.NewFakeClientWithScheme(newTestScheme(t)), 2, 0)
+
+	if delay, _ := p.Admit(FromObject(deploy)); delay != 0 {
+		t.Errorf("Expected the first update to be admitted, got delay %v", delay)
+	}
+	delay, _ := p.Admit(FromObject(deploy))
+	if delay <= 0 {
+		t.Errorf("Expected the second update to be deferred")
+	}
+	// deferred updates don't consume the budget
+	if next, _ := p.Admit(FromObject(deploy)); next > delay {
+		t.Errorf("Expected the delay not to grow, got %v after %v", next, delay)
+	}
+}
+
+func TestRollingOut(t *testing.T) {
+	replicas := int32(2)
+	testCases := []struct {
+		name     string
+		input    runtime.Object
+		expected bool
+	}{
+		{
+			name:     "New generation not observed",
+			input:    rollingOut(newTestDeployment("ns", "app", "nginx")),
+			expected: true,
+		},
+		{
+			name: "Deployment rolled out",
+			input: &appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &replicas},
+				Status: appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2},
+			},
+			expected: false,
+		},
+		{
+			name: "Deployment old replicas remaining",
+			input: &appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &replicas},
+				Status: appsv1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 2},
+			},
+			expected: true,
+		},
+		{
+			name:     "DaemonSet updating",
+			input:    &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 1, NumberAvailable: 3}},
+			expected: true,
+		},
+		{
+			name:     "DaemonSet rolled out",
+			input:    &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 3}},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if output := FromObject(tc.input).RollingOut(); output != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, output)
+			}
+		})
+	}
+}
+
+// rollingOut marks the deployment as having a change not observed yet by its controller
+func rollingOut(d *appsv1.Deployment) *appsv1.Deployment {
+	d.Generation = 2
+	d.Status.ObservedGeneration = 1
+	return d
+}
+
+// rolledOut marks the single replica deployment as fully rolled out
+func rolledOut(d *appsv1.Deployment) *appsv1.Deployment {
+	d.Status.Replicas = 1
+	d.Status.UpdatedReplicas = 1
+	d.Status.AvailableReplicas = 1
+	return d
+}
@@ -0,0 +1,66 @@
+package workload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"image-clone-controller/pkg/registry"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "image-clone-controller"
+)
+
+// ensurePullSecret creates the pull Secret of the namespace with the credentials of the registry client
+// or updates it if the credentials changed. Secrets not created by the controller are left untouched.
+func (m *Migrator) ensurePullSecret(namespace, name string, regClient *registry.Client) error {
+	data, err := regClient.DockerConfigJSON()
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	err = m.apiReader.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, secret)
+	if errors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+				Labels:    map[string]string{managedByLabel: managedByValue},
+			},
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: data},
+		}
+		return m.client.Create(context.Background(), secret)
+	}
+	if err != nil {
+		return err
+	}
+
+	if secret.Labels[managedByLabel] != managedByValue {
+		return fmt.Errorf("secret %s/%s is not managed by the controller", namespace, name)
+	}
+	if bytes.Equal(secret.Data[corev1.DockerConfigJsonKey], data) {
+		return nil
+	}
+	secret.Data = map[string][]byte{corev1.DockerConfigJsonKey: data}
+	return m.client.Update(context.Background(), secret)
+}
+
+// addPullSecret adds the pull Secret to the pod template, false is returned if it was already there
+func addPullSecret(template *corev1.PodTemplateSpec, name string) bool {
+	for _, s := range template.Spec.ImagePullSecrets {
+		if s.Name == name {
+			return false
+		}
+	}
+	template.Spec.ImagePullSecrets = append(template.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	return true
+}
@@ -0,0 +1,89 @@
+package workload
+
+import (
+	"context"
+	"testing"
+
+	"image-clone-controller/pkg/registry"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEnsurePullSecret(t *testing.T) {
+	regClient := registry.NewClient("quay.io", "org", "user", "pwd", 0)
+	expectedData, err := regClient.DockerConfigJSON()
+	if err != nil {
+		t.Fatalf("Failed to build the docker config: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		existing      []runtime.Object
+		expectedError bool
+	}{
+		{
+			name: "New secret",
+		},
+		{
+			name: "Rotated credentials",
+			existing: []runtime.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pull", Labels: map[string]string{managedByLabel: managedByValue}},
+				Type:       corev1.SecretTypeDockerConfigJson,
+				Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+			}},
+		},
+		{
+			name: "Secret not managed by the controller",
+			existing: []runtime.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pull"},
+				Data:       map[string][]byte{"token": []byte("secret")},
+			}},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cli := fake.NewFakeClientWithScheme(newTestScheme(t), tc.existing...)
+			m := &Migrator{client: cli, apiReader: cli}
+
+			err := m.ensurePullSecret("ns", "pull", regClient)
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("Got no error while one is expected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got not expected error: %v", err)
+			}
+
+			secret := &corev1.Secret{}
+			if err := cli.Get(context.Background(), types.NamespacedName{Namespace: "ns", Name: "pull"}, secret); err != nil {
+				t.Fatalf("Failed to get the secret: %v", err)
+			}
+			if string(secret.Data[corev1.DockerConfigJsonKey]) != string(expectedData) {
+				t.Errorf("Expected docker config %s, got %s", expectedData, secret.Data[corev1.DockerConfigJsonKey])
+			}
+		})
+	}
+}
+
+func TestAddPullSecret(t *testing.T) {
+	template := &corev1.PodTemplateSpec{}
+	template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "other"}}
+
+	if !addPullSecret(template, "pull") {
+		t.Errorf("Expected the pull secret to be added")
+	}
+	if addPullSecret(template, "pull") {
+		t.Errorf("Expected the pull secret not to be added twice")
+	}
+	if len(template.Spec.ImagePullSecrets) != 2 {
+		t.Errorf("Expected 2 pull secrets, got %v", template.Spec.ImagePullSecrets)
+	}
+}
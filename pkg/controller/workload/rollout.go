@@ -0,0 +1,198 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/metrics"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// OriginalImagesAnnotation is the workload annotation with the images of the containers before their migration,
+	// as a JSON object from the container names to the images
+	OriginalImagesAnnotation = "imageclone.io/original-images"
+	// RolledBackImagesAnnotation is the workload annotation with the images reverted after pull failures, as a JSON list.
+	// These images are not migrated anymore until the annotation is removed.
+	RolledBackImagesAnnotation = "imageclone.io/rolled-back-images"
+)
+
+// container waiting reasons of the image pull failures
+var pullFailureReasons = map[string]bool{
+	"ErrImagePull":     true,
+	"ImagePullBackOff": true,
+}
+
+// PullFailures returns the images of the pod's containers failing to be pulled by container name
+func PullFailures(pod *corev1.Pod) map[string]string {
+	failures := map[string]string{}
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.State.Waiting == nil || !pullFailureReasons[s.State.Waiting.Reason] {
+			continue
+		}
+		for _, c := range pod.Spec.Containers {
+			if c.Name == s.Name {
+				failures[c.Name] = c.Image
+			}
+		}
+	}
+	return failures
+}
+
+// verifyRollout checks the pods of a migrated workload which is rolling out for failures to pull the backup images.
+// The failing containers are reverted to their original images if configured so.
+// Returns true if the workload was rolled back.
+func (m *Migrator) verifyRollout(logger logr.Logger, w *Workload, cfg *config.Config) (bool, error) {
+	originals := originalImages(w)
+	if !cfg.VerifyRollouts || len(originals) == 0 || !w.RollingOut() {
+		return false, nil
+	}
+
+	pods, err := w.Pods(m.client)
+	if err != nil {
+		return false, err
+	}
+	// only the pods of the migrated template are considered
+	current := map[string]string{}
+	for _, c := range w.Template.Spec.Containers {
+		current[c.Name] = c.Image
+	}
+	failed := map[string]string{}
+	for i := range pods {
+		for container, image := range PullFailures(&pods[i]) {
+			if original, migrated := originals[container]; migrated && image != original && current[container] == image {
+				failed[container] = image
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return false, nil
+	}
+
+	containers := []string{}
+	for container := range failed {
+		containers = append(containers, container)
+	}
+	sort.Strings(containers)
+	for _, container := range containers {
+		logger.Info("Pods fail to pull the backup image", "Container", container, "Image", failed[container])
+		m.recorder.Eventf(w.Object, corev1.EventTypeWarning, "BackupImagePullFailed", "Pods fail to pull the backup image %s of container %s", failed[container], container)
+	}
+	if !cfg.RollbackOnPullFailure {
+		return false, nil
+	}
+
+	// reverting the failing containers, their images are not migrated again
+	rolledBack := rolledBackImages(w)
+	for i, c := range w.Template.Spec.Containers {
+		if _, exists := failed[c.Name]; exists {
+			w.Template.Spec.Containers[i].Image = originals[c.Name]
+			rolledBack[originals[c.Name]] = true
+			delete(originals, c.Name)
+		}
+	}
+	if err := setOriginalImages(w, originals); err != nil {
+		return false, err
+	}
+	if err := setRolledBackImages(w, rolledBack); err != nil {
+		return false, err
+	}
+
+	logger.Info(fmt.Sprintf("Rolling back the %s to the original images", w.Kind), "Containers", containers)
+	if err := m.client.Update(context.Background(), w.Object); err != nil {
+		return false, err
+	}
+	metrics.WorkloadRolledBack(w.Kind)
+	m.recorder.Eventf(w.Object, corev1.EventTypeNormal, "RolledBack", "Containers %s reverted to their original images, remove the %s annotation to migrate them again",
+		strings.Join(containers, ", "), RolledBackImagesAnnotation)
+	return true, nil
+}
+
+// originalImages returns the original images of the migrated containers by container name
+func originalImages(w *Workload) map[string]string {
+	images := map[string]string{}
+	if annotation, exists := w.Object.GetAnnotations()[OriginalImagesAnnotation]; exists {
+		// an invalid annotation only disables the verification
+		_ = json.Unmarshal([]byte(annotation), &images)
+	}
+	return images
+}
+
+// setOriginalImages records the original images of the migrated containers
+func setOriginalImages(w *Workload, images map[string]string) error {
+	if len(images) == 0 {
+		removeAnnotation(w, OriginalImagesAnnotation)
+		return nil
+	}
+	value, err := json.Marshal(images)
+	if err != nil {
+		return err
+	}
+	setAnnotation(w, OriginalImagesAnnotation, string(value))
+	return nil
+}
+
+// rolledBackImages returns the images which were reverted after pull failures
+func rolledBackImages(w *Workload) map[string]bool {
+	images := map[string]bool{}
+	if annotation, exists := w.Object.GetAnnotations()[RolledBackImagesAnnotation]; exists {
+		list := []string{}
+		// an invalid annotation doesn't prevent any migration
+		_ = json.Unmarshal([]byte(annotation), &list)
+		for _, image := range list {
+			images[image] = true
+		}
+	}
+	return images
+}
+
+// setRolledBackImages records the images which were reverted after pull failures
+func setRolledBackImages(w *Workload, images map[string]bool) error {
+	list := []string{}
+	for image := range images {
+		list = append(list, image)
+	}
+	sort.Strings(list)
+	value, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	setAnnotation(w, RolledBackImagesAnnotation, string(value))
+	return nil
+}
+
+func setAnnotation(w *Workload, key, value string) {
+	annotations := w.Object.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	w.Object.SetAnnotations(annotations)
+}
+
+func removeAnnotation(w *Workload, key string) {
+	annotations := w.Object.GetAnnotations()
+	delete(annotations, key)
+	w.Object.SetAnnotations(annotations)
+}
+
+// patchAnnotation sets the annotation of the workload in the cluster without the other changes of the workload
+func patchAnnotation(c client.Writer, w *Workload, key, value string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{key: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return c.Patch(context.Background(), w.Object.DeepCopyObject(), client.ConstantPatch(types.MergePatchType, patch))
+}
@@ -0,0 +1,140 @@
+package workload
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"image-clone-controller/pkg/config"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestVerifyRollout(t *testing.T) {
+	testCases := []struct {
+		name               string
+		rollback           bool
+		originals          string
+		podImage           string
+		podReason          string
+		expectedRolledBack bool
+		expectedImage      string
+		expectedEvent      bool
+		expectedAnnotation string
+	}{
+		{
+			name:          "Pulled",
+			rollback:      true,
+			originals:     `{"a":"nginx"}`,
+			podImage:      "quay.io/org/nginx",
+			podReason:     "ContainerCreating",
+			expectedImage: "quay.io/org/nginx",
+		},
+		{
+			name:          "Not migrated",
+			rollback:      true,
+			podImage:      "quay.io/org/nginx",
+			podReason:     "ImagePullBackOff",
+			expectedImage: "quay.io/org/nginx",
+		},
+		{
+			name:          "Pods of the previous template",
+			rollback:      true,
+			originals:     `{"a":"nginx"}`,
+			podImage:      "nginx:old",
+			podReason:     "ErrImagePull",
+			expectedImage: "quay.io/org/nginx",
+		},
+		{
+			name:          "Pull failure without rollback",
+			originals:     `{"a":"nginx"}`,
+			podImage:      "quay.io/org/nginx",
+			podReason:     "ImagePullBackOff",
+			expectedImage: "quay.io/org/nginx",
+			expectedEvent: true,
+		},
+		{
+			name:               "Pull failure with rollback",
+			rollback:           true,
+			originals:          `{"a":"nginx"}`,
+			podImage:           "quay.io/org/nginx",
+			podReason:          "ErrImagePull",
+			expectedRolledBack: true,
+			expectedImage:      "nginx",
+			expectedEvent:      true,
+			expectedAnnotation: `["nginx"]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{VerifyRollouts: true, RollbackOnPullFailure: tc.rollback}
+
+			deploy := newTestDeployment("ns", "app", "quay.io/org/nginx")
+			deploy.Generation = 2
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}}
+			if tc.originals != "" {
+				deploy.Annotations = map[string]string{OriginalImagesAnnotation: tc.originals}
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1", Labels: map[string]string{"app": "app"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "a", Image: tc.podImage}}},
+				Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "a",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: tc.podReason}},
+				}}},
+			}
+			cli := fake.NewFakeClientWithScheme(newTestScheme(t), deploy, pod)
+			recorder := record.NewFakeRecorder(10)
+			m := &Migrator{client: cli, apiReader: cli, recorder: recorder}
+
+			rolledBack, err := m.verifyRollout(logf.Log, FromObject(deploy), cfg)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if rolledBack != tc.expectedRolledBack {
+				t.Errorf("Expected rolled back: %v, got: %v", tc.expectedRolledBack, rolledBack)
+			}
+			if tc.expectedEvent != (len(recorder.Events) > 0) {
+				t.Errorf("Expected event: %v, got %d events", tc.expectedEvent, len(recorder.Events))
+			}
+
+			output := &appsv1.Deployment{}
+			if err := cli.Get(context.Background(), types.NamespacedName{Namespace: "ns", Name: "app"}, output); err != nil {
+				t.Fatalf("Failed to get the deployment: %v", err)
+			}
+			if image := output.Spec.Template.Spec.Containers[0].Image; image != tc.expectedImage {
+				t.Errorf("Expected image %q, got %q", tc.expectedImage, image)
+			}
+			if annotation := output.Annotations[RolledBackImagesAnnotation]; annotation != tc.expectedAnnotation {
+				t.Errorf("Expected rolled back images %q, got %q", tc.expectedAnnotation, annotation)
+			}
+		})
+	}
+}
+
+func TestPullFailures(t *testing.T) {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "a", Image: "nginx"},
+			{Name: "b", Image: "redis"},
+			{Name: "c", Image: "busybox"},
+		}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "a", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}},
+			{Name: "b", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			{Name: "c", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+		}},
+	}
+
+	expected := map[string]string{"a": "nginx"}
+	if output := PullFailures(pod); !reflect.DeepEqual(output, expected) {
+		t.Errorf("Expected %v, got %v", expected, output)
+	}
+}
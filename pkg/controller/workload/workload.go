@@ -0,0 +1,263 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// KindDeployment is the kind of the Deployment workloads
+	KindDeployment = "Deployment"
+	// KindDaemonSet is the kind of the DaemonSet workloads
+	KindDaemonSet = "DaemonSet"
+	// RestartedAtAnnotation is the pod template annotation changed to restart the pods, like kubectl rollout restart does
+	RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+	// RestartRequestedAnnotation is the workload annotation requesting the restart of its pods, e.g. to run a refreshed backup image.
+	// The restart is done by the workload controller like an update: within the maintenance windows and paced.
+	RestartRequestedAnnotation = "imageclone.io/restart-requested"
+	// ImagesField indexes the workloads by the images of their containers
+	ImagesField = "spec.template.spec.containers.image"
+)
+
+// Object is a Kubernetes object with metadata
+type Object interface {
+	runtime.Object
+	metav1.Object
+}
+
+// Workload is the common view of the objects having a pod template
+type Workload struct {
+	// Kind of the workload
+	Kind string
+	// Object is the workload itself
+	Object Object
+	// Template is the pod template of the workload
+	Template *corev1.PodTemplateSpec
+}
+
+// New returns a workload for the given object and its pod template
+func New(kind string, obj Object, template *corev1.PodTemplateSpec) *Workload {
+	return &Workload{
+		Kind:     kind,
+		Object:   obj,
+		Template: template,
+	}
+}
+
+// FromObject returns the workload for the given object, nil if the object is not a supported workload
+func FromObject(obj runtime.Object) *Workload {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return New(KindDeployment, o, &o.Spec.Template)
+	case *appsv1.DaemonSet:
+		return New(KindDaemonSet, o, &o.Spec.Template)
+	}
+	return nil
+}
+
+// Get returns the referenced workload
+func Get(c client.Reader, ref v1alpha1.WorkloadReference) (*Workload, error) {
+	var obj Object
+	switch ref.Kind {
+	case KindDeployment:
+		obj = &appsv1.Deployment{}
+	case KindDaemonSet:
+		obj = &appsv1.DaemonSet{}
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %q", ref.Kind)
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, obj); err != nil {
+		return nil, err
+	}
+	return FromObject(obj), nil
+}
+
+// NamespacedName returns the namespace and the name of the workload
+func (w *Workload) NamespacedName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: w.Object.GetNamespace(),
+		Name:      w.Object.GetName(),
+	}
+}
+
+// Reference returns the reference of the workload used in the ImageBackups
+func (w *Workload) Reference() v1alpha1.WorkloadReference {
+	return v1alpha1.WorkloadReference{
+		Kind:      w.Kind,
+		Namespace: w.Object.GetNamespace(),
+		Name:      w.Object.GetName(),
+	}
+}
+
+// Images returns the images of all the workload's containers
+func (w *Workload) Images() []string {
+	images := []string{}
+	for _, c := range w.Template.Spec.Containers {
+		images = append(images, c.Image)
+	}
+	return images
+}
+
+// Uses returns true if any of the workload's containers uses the given image
+func (w *Workload) Uses(image string) bool {
+	for _, c := range w.Template.Spec.Containers {
+		if c.Image == image {
+			return true
+		}
+	}
+	return false
+}
+
+// Owner returns the reference to the object controlling the workload, nil if it's not controlled
+func (w *Workload) Owner() *metav1.OwnerReference {
+	return metav1.GetControllerOf(w.Object)
+}
+
+// RollingOut returns true if the last change of the workload is not fully rolled out yet
+func (w *Workload) RollingOut() bool {
+	switch o := w.Object.(type) {
+	case *appsv1.Deployment:
+		if o.Status.ObservedGeneration < o.Generation {
+			return true
+		}
+		replicas := int32(1)
+		if o.Spec.Replicas != nil {
+			replicas = *o.Spec.Replicas
+		}
+		return o.Status.UpdatedReplicas < replicas || o.Status.Replicas > o.Status.UpdatedReplicas || o.Status.AvailableReplicas < o.Status.UpdatedReplicas
+	case *appsv1.DaemonSet:
+		if o.Status.ObservedGeneration < o.Generation {
+			return true
+		}
+		return o.Status.UpdatedNumberScheduled < o.Status.DesiredNumberScheduled || o.Status.NumberAvailable < o.Status.DesiredNumberScheduled
+	}
+	return false
+}
+
+// Selector returns the label selector of the workload's pods
+func (w *Workload) Selector() *metav1.LabelSelector {
+	switch o := w.Object.(type) {
+	case *appsv1.Deployment:
+		return o.Spec.Selector
+	case *appsv1.DaemonSet:
+		return o.Spec.Selector
+	}
+	return nil
+}
+
+// Pods returns the pods of the workload
+func (w *Workload) Pods(c client.Reader) ([]corev1.Pod, error) {
+	if w.Selector() == nil {
+		return nil, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(w.Selector())
+	if err != nil {
+		return nil, err
+	}
+	pods := &corev1.PodList{}
+	if err := c.List(context.Background(), pods, client.InNamespace(w.Object.GetNamespace()), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// RequestRestart requests the restart of the workload's pods, done by the next reconciliation of the workload
+func (w *Workload) RequestRestart(c client.Writer, at time.Time) error {
+	return patchAnnotation(c, w, RestartRequestedAnnotation, at.Format(time.RFC3339))
+}
+
+// RestartRequested returns true if the restart of the workload's pods was requested
+func (w *Workload) RestartRequested() bool {
+	_, requested := w.Object.GetAnnotations()[RestartRequestedAnnotation]
+	return requested
+}
+
+// Restart sets the workload to roll out its pods again once updated, the restart request is cleared
+func (w *Workload) Restart(at time.Time) {
+	if w.Template.Annotations == nil {
+		w.Template.Annotations = map[string]string{}
+	}
+	w.Template.Annotations[RestartedAtAnnotation] = at.Format(time.RFC3339)
+	removeAnnotation(w, RestartRequestedAnnotation)
+}
+
+// ListAll returns all the supported workloads from the given namespace, from all namespaces if empty
+func ListAll(c client.Reader, namespace string) ([]*Workload, error) {
+	workloads := []*Workload{}
+
+	deployments := &appsv1.DeploymentList{}
+	if err := c.List(context.Background(), deployments, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		workloads = append(workloads, FromObject(&deployments.Items[i]))
+	}
+
+	daemonsets := &appsv1.DaemonSetList{}
+	if err := c.List(context.Background(), daemonsets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range daemonsets.Items {
+		workloads = append(workloads, FromObject(&daemonsets.Items[i]))
+	}
+
+	return workloads, nil
+}
+
+// IndexImages indexes the workloads of the supported kinds by the images of their containers, for ListUsing
+func IndexImages(indexer client.FieldIndexer) error {
+	for _, obj := range []runtime.Object{&appsv1.Deployment{}, &appsv1.DaemonSet{}} {
+		err := indexer.IndexField(obj, ImagesField, func(o runtime.Object) []string {
+			if w := FromObject(o); w != nil {
+				return w.Images()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListUsing returns the supported workloads from the given namespace, from all namespaces if empty,
+// using any of the given images. The workloads are looked up through the ImagesField index of the cache.
+func ListUsing(c client.Reader, namespace string, images ...string) ([]*Workload, error) {
+	workloads := []*Workload{}
+	found := map[string]bool{}
+	for _, image := range images {
+		for _, list := range []runtime.Object{&appsv1.DeploymentList{}, &appsv1.DaemonSetList{}} {
+			if err := c.List(context.Background(), list, client.InNamespace(namespace), client.MatchingField(ImagesField, image)); err != nil {
+				return nil, err
+			}
+			items, err := meta.ExtractList(list)
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range items {
+				// readers without the index ignore the field selector
+				w := FromObject(item)
+				if w == nil || !w.Uses(image) {
+					continue
+				}
+				key := w.Kind + "/" + w.NamespacedName().String()
+				if !found[key] {
+					found[key] = true
+					workloads = append(workloads, w)
+				}
+			}
+		}
+	}
+	return workloads, nil
+}
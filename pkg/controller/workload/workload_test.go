@@ -0,0 +1,77 @@
+package workload
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRestart(t *testing.T) {
+	cli := fake.NewFakeClientWithScheme(newTestScheme(t), newTestDeployment("ns", "app", "nginx"))
+	ref := v1alpha1.WorkloadReference{Kind: KindDeployment, Namespace: "ns", Name: "app"}
+
+	w, err := Get(cli, ref)
+	if err != nil {
+		t.Fatalf("Failed to get the workload: %v", err)
+	}
+	at := time.Date(2020, 3, 4, 12, 0, 0, 0, time.UTC)
+	if err := w.RequestRestart(cli, at); err != nil {
+		t.Fatalf("Failed to request the restart of the workload: %v", err)
+	}
+
+	output, err := Get(cli, ref)
+	if err != nil {
+		t.Fatalf("Failed to get the workload: %v", err)
+	}
+	if !output.RestartRequested() {
+		t.Errorf("Expected the restart to be requested")
+	}
+	if _, restarted := output.Template.Annotations[RestartedAtAnnotation]; restarted {
+		t.Errorf("Expected the request not to restart the pods")
+	}
+
+	output.Restart(at)
+	if restartedAt := output.Template.Annotations[RestartedAtAnnotation]; restartedAt != "2020-03-04T12:00:00Z" {
+		t.Errorf("Expected the restart time in the pod template, got %q", restartedAt)
+	}
+	if output.RestartRequested() {
+		t.Errorf("Expected the restart request to be cleared")
+	}
+
+	if _, err := Get(cli, v1alpha1.WorkloadReference{Kind: "StatefulSet", Namespace: "ns", Name: "app"}); err == nil {
+		t.Errorf("Expected an error for an unsupported kind")
+	}
+}
+
+func TestListUsing(t *testing.T) {
+	daemonset := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "agent"}}
+	daemonset.Spec.Template.Spec.Containers = []corev1.Container{{Name: "a", Image: "quay.io/org/nginx"}}
+	cli := fake.NewFakeClientWithScheme(newTestScheme(t),
+		newTestDeployment("ns", "app", "nginx", "redis"),
+		newTestDeployment("ns", "db", "postgres"),
+		daemonset)
+
+	workloads, err := ListUsing(cli, "", "nginx", "redis", "quay.io/org/nginx")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, w := range workloads {
+		names = append(names, w.Kind+"/"+w.NamespacedName().String())
+	}
+	expected := []string{"Deployment/ns/app", "DaemonSet/other/agent"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, names)
+	}
+
+	if workloads, err := ListUsing(cli, "ns", "quay.io/org/nginx"); err != nil || len(workloads) != 0 {
+		t.Errorf("Expected no workload of the namespace, got %v (error: %v)", workloads, err)
+	}
+}
@@ -0,0 +1,231 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/controller/workload"
+	"image-clone-controller/pkg/image"
+	"image-clone-controller/pkg/metrics"
+	"image-clone-controller/pkg/registry"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var log = logf.Log.WithName("gc")
+
+var _ manager.Runnable = &Collector{}
+var _ manager.LeaderElectionRunnable = &Collector{}
+
+// Collector deletes the backup images which are not used by any workload anymore
+type Collector struct {
+	// split client (reads from the cache, writes to API)
+	client client.Client
+	// reads the Secrets, which are not cached, from the API
+	apiReader client.Reader
+	regClient *registry.Client
+	interval  time.Duration
+}
+
+// NewCollectorFromConfig returns a garbage collector set from the program's config,
+// the retention settings in effect are used by each collection
+func NewCollectorFromConfig(c client.Client, apiReader client.Reader) *Collector {
+	return &Collector{
+		client:    c,
+		apiReader: apiReader,
+		regClient: registry.NewClientFromConfig(),
+		interval:  config.GlobalConfig.GCInterval,
+	}
+}
+
+// Start collects the garbage periodically until the stop channel is closed
+func (c *Collector) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if _, err := c.Collect(); err != nil {
+				log.Error(err, "Failed to collect the unused backup images")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection returns true: only the leader deletes the backup images
+func (c *Collector) NeedLeaderElection() bool {
+	return true
+}
+
+// Collect deletes the unused backup images, they are only reported in dry-run mode.
+// Returns the collected ImageBackups.
+func (c *Collector) Collect() ([]v1alpha1.ImageBackup, error) {
+	cfg := config.Current()
+	backups := &v1alpha1.ImageBackupList{}
+	if err := c.client.List(context.Background(), backups); err != nil {
+		return nil, err
+	}
+
+	candidates := selectCandidates(backups.Items, time.Now(), cfg.GCGracePeriod, cfg.GCKeepLast)
+	size := int64(0)
+	for _, b := range candidates {
+		size += b.Status.Size
+	}
+	metrics.GCReclaimable(size)
+	log.Info("Unused backup images found", "Count", len(candidates), "Size", size, "DryRun", cfg.GCDryRun)
+	kept := keptManifests(backups.Items, candidates)
+
+	for i := range candidates {
+		b := &candidates[i]
+		logger := log.WithValues("imagebackup", b.Name, "Image", b.Status.Destination, "Digest", b.Status.Digest, "UnreferencedSince", b.Status.UnreferencedSince)
+		if cfg.GCDryRun {
+			logger.Info("Would delete the unused backup image")
+			continue
+		}
+		err := c.delete(b, kept)
+		metrics.GCDeleted(err)
+		if err != nil {
+			logger.Error(err, "Failed to delete the unused backup image")
+			continue
+		}
+		logger.Info("Unused backup image deleted")
+	}
+	return candidates, nil
+}
+
+// delete deletes the copies of the backup image and its superseded manifests from the registries and then its ImageBackup.
+// The manifests of the kept backup images are not deleted.
+func (c *Collector) delete(b *v1alpha1.ImageBackup, kept map[string]bool) error {
+	regClient := c.regClient
+	if b.Spec.Namespace != "" {
+		nsClient, err := workload.NamespaceRegistryClient(c.client, c.apiReader, b.Spec.Namespace)
+		if err != nil {
+			return err
+		}
+		if nsClient == nil {
+			// the destination is gone, so are its credentials
+			return nil
+		}
+		regClient = nsClient
+	}
+	for _, name := range b.ReplicaImages() {
+		if !regClient.Belongs(name) {
+			// e.g. copy in a previous backup registry
+			continue
+		}
+		digests := b.Status.SupersededDigests
+		// the tag may point to another manifest than at the last copy
+		info, err := regClient.Inspect(name)
+		if err != nil {
+			exists, existsErr := regClient.ImageExists(name)
+			if existsErr != nil || exists {
+				return fmt.Errorf("failed to inspect %s: %v", name, err)
+			}
+			// already deleted, e.g. by a previous collection which failed later on
+		} else {
+			digests = append([]string{info.Digest}, digests...)
+		}
+
+		ref := image.Parse(name)
+		for i, digest := range digests {
+			if kept[manifestKey(name, digest)] {
+				continue
+			}
+			// the superseded manifests are untagged
+			target := name
+			if i > 0 || info == nil {
+				target = image.Reference{Registry: ref.Registry, Repository: ref.Repository, Digest: digest}.String()
+			}
+			if err := regClient.DeleteImage(target, digest); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.client.Delete(context.Background(), b); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// keptManifests returns the manifests of the copies of the backup images which are not deleted, by manifestKey
+func keptManifests(backups, candidates []v1alpha1.ImageBackup) map[string]bool {
+	deleted := map[string]bool{}
+	for _, b := range candidates {
+		deleted[b.Name] = true
+	}
+	kept := map[string]bool{}
+	for _, b := range backups {
+		if deleted[b.Name] || b.Status.Digest == "" {
+			continue
+		}
+		for _, name := range b.ReplicaImages() {
+			kept[manifestKey(name, b.Status.Digest)] = true
+		}
+	}
+	return kept
+}
+
+// manifestKey identifies the manifest with the given digest in the repository of the given image
+func manifestKey(fullName, digest string) string {
+	ref := image.Parse(fullName)
+	return ref.Registry + "/" + ref.Repository + "@" + digest
+}
+
+// selectCandidates returns the ImageBackups whose backup images can be deleted:
+// unused for longer than the grace period and not among the keepLast most recently copied images of their repository.
+// An image sharing its digest with a kept image is kept as deleting a manifest deletes all its tags.
+func selectCandidates(backups []v1alpha1.ImageBackup, now time.Time, grace time.Duration, keepLast int) []v1alpha1.ImageBackup {
+	repos := map[string][]v1alpha1.ImageBackup{}
+	names := []string{}
+	for _, b := range backups {
+		if b.Status.Destination == "" || b.Status.Digest == "" {
+			continue
+		}
+		ref := image.Parse(b.Status.Destination)
+		repo := ref.Registry + "/" + ref.Repository
+		if _, exists := repos[repo]; !exists {
+			names = append(names, repo)
+		}
+		repos[repo] = append(repos[repo], b)
+	}
+	sort.Strings(names)
+
+	candidates := []v1alpha1.ImageBackup{}
+	for _, repo := range names {
+		bs := repos[repo]
+		// most recent copies first
+		sort.SliceStable(bs, func(i, j int) bool {
+			ti, tj := bs[i].Status.LastSyncTime, bs[j].Status.LastSyncTime
+			if ti == nil || tj == nil {
+				return tj == nil && ti != nil
+			}
+			return ti.After(tj.Time)
+		})
+
+		kept, recent := map[string]bool{}, 0
+		for _, b := range bs {
+			if !kept[b.Status.Digest] && recent < keepLast {
+				kept[b.Status.Digest] = true
+				recent++
+			}
+			if since := b.Status.UnreferencedSince; since == nil || now.Sub(since.Time) < grace {
+				kept[b.Status.Digest] = true
+			}
+		}
+		for _, b := range bs {
+			if !kept[b.Status.Digest] {
+				candidates = append(candidates, b)
+			}
+		}
+	}
+	return candidates
+}
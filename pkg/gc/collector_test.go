@@ -0,0 +1,122 @@
+package gc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSelectCandidates(t *testing.T) {
+	now := time.Date(2020, 3, 4, 12, 0, 0, 0, time.UTC)
+	backup := func(name, dest, digest string, syncedDaysAgo, unusedDaysAgo int) v1alpha1.ImageBackup {
+		synced := metav1.NewTime(now.AddDate(0, 0, -syncedDaysAgo))
+		b := v1alpha1.ImageBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1alpha1.ImageBackupStatus{
+				Destination:  dest,
+				Digest:       digest,
+				LastSyncTime: &synced,
+			},
+		}
+		if unusedDaysAgo >= 0 {
+			since := metav1.NewTime(now.AddDate(0, 0, -unusedDaysAgo))
+			b.Status.UnreferencedSince = &since
+		}
+		return b
+	}
+
+	testCases := []struct {
+		name     string
+		backups  []v1alpha1.ImageBackup
+		keepLast int
+		expected []string
+	}{
+		{
+			name: "Used",
+			backups: []v1alpha1.ImageBackup{
+				backup("a", "quay.io/org/nginx:1.17", "sha256:a", 30, -1),
+			},
+			expected: []string{},
+		},
+		{
+			name: "Unused within the grace period",
+			backups: []v1alpha1.ImageBackup{
+				backup("a", "quay.io/org/nginx:1.17", "sha256:a", 30, 3),
+			},
+			expected: []string{},
+		},
+		{
+			name: "Unused",
+			backups: []v1alpha1.ImageBackup{
+				backup("a", "quay.io/org/nginx:1.17", "sha256:a", 30, 10),
+				backup("b", "quay.io/org/redis:5", "sha256:b", 30, 10),
+			},
+			expected: []string{"a", "b"},
+		},
+		{
+			name: "Most recent kept",
+			backups: []v1alpha1.ImageBackup{
+				backup("a", "quay.io/org/nginx:1.17", "sha256:a", 30, 10),
+				backup("b", "quay.io/org/nginx:1.18", "sha256:b", 20, 10),
+				backup("c", "quay.io/org/nginx:1.19", "sha256:c", 10, 10),
+				backup("d", "quay.io/org/redis:5", "sha256:d", 30, 10),
+			},
+			keepLast: 2,
+			expected: []string{"a"},
+		},
+		{
+			name: "Digest shared with a used image",
+			backups: []v1alpha1.ImageBackup{
+				backup("a", "quay.io/org/nginx:1.19", "sha256:a", 30, 10),
+				backup("b", "quay.io/org/nginx:stable", "sha256:a", 30, -1),
+			},
+			expected: []string{},
+		},
+		{
+			name: "Not backed up",
+			backups: []v1alpha1.ImageBackup{
+				{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Status: v1alpha1.ImageBackupStatus{LastError: "timeout"}},
+			},
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := []string{}
+			for _, b := range selectCandidates(tc.backups, now, 7*24*time.Hour, tc.keepLast) {
+				output = append(output, b.Name)
+			}
+			if !reflect.DeepEqual(output, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestKeptManifests(t *testing.T) {
+	backup := func(name, dest, digest string) v1alpha1.ImageBackup {
+		return v1alpha1.ImageBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     v1alpha1.ImageBackupStatus{Destination: dest, Digest: digest},
+		}
+	}
+	backups := []v1alpha1.ImageBackup{
+		backup("a", "quay.io/org/nginx:1.17", "sha256:a"),
+		backup("b", "quay.io/org/nginx:1.18", "sha256:b"),
+		backup("c", "quay.io/org/redis:5", ""),
+	}
+
+	kept := keptManifests(backups, backups[:1])
+	expected := map[string]bool{"quay.io/org/nginx@sha256:b": true}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, kept)
+	}
+	if kept[manifestKey("quay.io/org/nginx:1.17", "sha256:a")] || !kept[manifestKey("quay.io/org/nginx@sha256:b", "sha256:b")] {
+		t.Error("Expected the manifests to be identified by repository and digest")
+	}
+}
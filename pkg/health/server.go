@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// LivenessPath is the path of the liveness endpoint
+	LivenessPath = "/healthz"
+	// ReadinessPath is the path of the readiness endpoint
+	ReadinessPath = "/readyz"
+
+	shutdownTimeout = 5 * time.Second
+)
+
+var log = logf.Log.WithName("health")
+
+var _ manager.Runnable = &Server{}
+var _ manager.LeaderElectionRunnable = &Server{}
+
+// Checker returns an error if the checked component is not healthy
+type Checker func() error
+
+// Server serves the liveness and readiness endpoints
+type Server struct {
+	addr      string
+	readiness Checker
+}
+
+// NewServer returns a health server listening on the given address,
+// readiness endpoint fails if the given checker returns an error
+func NewServer(addr string, readiness Checker) *Server {
+	return &Server{
+		addr:      addr,
+		readiness: readiness,
+	}
+}
+
+// Start serves the health endpoints until the stop channel is closed
+func (s *Server) Start(stop <-chan struct{}) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: s.handler()}
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Error(err, "Failed to shutdown the health server")
+		}
+	}()
+
+	log.Info("Serving health probes", "Address", s.addr)
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection returns false: the probes are needed on all the replicas
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// handler returns the handler of the health endpoints
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(LivenessPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc(ReadinessPath, func(w http.ResponseWriter, r *http.Request) {
+		if err := s.readiness(); err != nil {
+			log.Info("Readiness check failed", "Error", err.Error())
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
+
+// CachedChecker returns a checker which calls the given one at most once per ttl,
+// the last result is returned in between. Useful for the checks hitting external services.
+func CachedChecker(check Checker, ttl time.Duration) Checker {
+	var lock sync.Mutex
+	var last time.Time
+	var lastErr error
+	return func() error {
+		lock.Lock()
+		defer lock.Unlock()
+		if now := time.Now(); now.Sub(last) >= ttl {
+			lastErr = check()
+			last = now
+		}
+		return lastErr
+	}
+}
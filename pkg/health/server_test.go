@@ -0,0 +1,69 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		ready    error
+		expected int
+	}{
+		{
+			name:     "Alive",
+			path:     LivenessPath,
+			expected: http.StatusOK,
+		},
+		{
+			name:     "Alive while not ready",
+			path:     LivenessPath,
+			ready:    errors.New("registry unreachable"),
+			expected: http.StatusOK,
+		},
+		{
+			name:     "Ready",
+			path:     ReadinessPath,
+			expected: http.StatusOK,
+		},
+		{
+			name:     "Not ready",
+			path:     ReadinessPath,
+			ready:    errors.New("registry unreachable"),
+			expected: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := NewServer("", func() error { return tc.ready })
+			rec := httptest.NewRecorder()
+			srv.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if rec.Code != tc.expected {
+				t.Errorf("Expected %d, got %d", tc.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestCachedChecker(t *testing.T) {
+	calls := 0
+	check := CachedChecker(func() error {
+		calls++
+		return nil
+	}, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if err := check(); err != nil {
+			t.Errorf("Got not expected error: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+	}
+}
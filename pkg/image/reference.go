@@ -0,0 +1,89 @@
+package image
+
+import (
+	"strings"
+)
+
+const (
+	// DefaultRegistry is the registry used for the references without one
+	DefaultRegistry = "docker.io"
+	// DefaultTag is the tag used for the references without tag nor digest
+	DefaultTag = "latest"
+
+	officialRepositoryPrefix = "library/"
+)
+
+// docker hub has several names
+var registryAliases = map[string]string{
+	"index.docker.io":      DefaultRegistry,
+	"registry-1.docker.io": DefaultRegistry,
+}
+
+// Reference is a container image reference split into its parts
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// Parse splits the given image reference into its parts.
+// Docker's defaults are applied: docker.io registry, library/ organization and latest tag.
+func Parse(ref string) Reference {
+	ref = strings.TrimSpace(ref)
+	r := Reference{}
+
+	if i := strings.Index(ref, "@"); i >= 0 {
+		r.Digest = ref[i+1:]
+		ref = ref[:i]
+	}
+
+	// tag is after the last colon unless the colon is a part of the registry's port
+	if i := strings.LastIndex(ref, ":"); i >= 0 && !strings.Contains(ref[i+1:], "/") {
+		r.Tag = ref[i+1:]
+		ref = ref[:i]
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = DefaultTag
+	}
+
+	// first component is a registry only if it looks like a host
+	if i := strings.Index(ref, "/"); i >= 0 && isHost(ref[:i]) {
+		r.Registry = NormalizeRegistry(ref[:i])
+		ref = ref[i+1:]
+	} else {
+		r.Registry = DefaultRegistry
+	}
+
+	if r.Registry == DefaultRegistry && !strings.Contains(ref, "/") {
+		ref = officialRepositoryPrefix + ref
+	}
+	r.Repository = ref
+
+	return r
+}
+
+// String returns the fully qualified reference
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// NormalizeRegistry returns the canonical name of the given registry
+func NormalizeRegistry(registry string) string {
+	if n, exists := registryAliases[registry]; exists {
+		return n
+	}
+	return registry
+}
+
+// isHost returns true if the given reference component is a registry host
+func isHost(s string) bool {
+	return strings.ContainsAny(s, ".:") || s == "localhost"
+}
@@ -0,0 +1,63 @@
+package image
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected Reference
+	}{
+		{
+			name:     "Official image",
+			input:    "nginx",
+			expected: Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			name:     "Official image with tag",
+			input:    "redis:6",
+			expected: Reference{Registry: "docker.io", Repository: "library/redis", Tag: "6"},
+		},
+		{
+			name:     "Docker hub organization",
+			input:    "coredns/coredns:1.3.1",
+			expected: Reference{Registry: "docker.io", Repository: "coredns/coredns", Tag: "1.3.1"},
+		},
+		{
+			name:     "Docker hub alias",
+			input:    "registry-1.docker.io/coredns/coredns:1.3.1",
+			expected: Reference{Registry: "docker.io", Repository: "coredns/coredns", Tag: "1.3.1"},
+		},
+		{
+			name:     "Other registry",
+			input:    "quay.io/kubermatic/openvpn:v0.5",
+			expected: Reference{Registry: "quay.io", Repository: "kubermatic/openvpn", Tag: "v0.5"},
+		},
+		{
+			name:     "Registry with port",
+			input:    "localhost:5000/team/app",
+			expected: Reference{Registry: "localhost:5000", Repository: "team/app", Tag: "latest"},
+		},
+		{
+			name:     "Digest",
+			input:    "quay.io/team/app@sha256:abc",
+			expected: Reference{Registry: "quay.io", Repository: "team/app", Digest: "sha256:abc"},
+		},
+		{
+			name:     "Tag and digest",
+			input:    "  gcr.io/team/sub/app:1.0@sha256:abc  ",
+			expected: Reference{Registry: "gcr.io", Repository: "team/sub/app", Tag: "1.0", Digest: "sha256:abc"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := Parse(tc.input)
+			if output != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package manifests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/registry"
+
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ActionBackedUp means that the image was copied to the backup registry
+	ActionBackedUp = "backed-up"
+	// ActionPlanned means that the image would be copied to the backup registry (dry-run)
+	ActionPlanned = "planned"
+	// ActionAlreadyBackedUp means that the image is already a backup image
+	ActionAlreadyBackedUp = "already-backed-up"
+	// ActionSkipped means that the image is left as is by the image rules or the namespace filters
+	ActionSkipped = "skipped"
+	// ActionFailed means that the copy of the image failed, the image is left as is
+	ActionFailed = "failed"
+)
+
+// podSpecPaths are the paths to the pod spec of the supported kinds
+var podSpecPaths = map[string][]string{
+	"Pod":                   {"spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// imageLine matches the image field of a container in block style YAML, the only style the images are rewritten in
+var imageLine = regexp.MustCompile(`^(\s*(?:-\s+)?image:\s*)(["']?)([^"'\s#]+)(["']?)(\s*(?:#.*)?)$`)
+
+// Report describes the processing of the images of the manifests
+type Report struct {
+	DryRun bool          `json:"dryRun"`
+	Images []ImageReport `json:"images"`
+	// Failed is the number of the containers whose image failed to be copied
+	Failed int `json:"failed"`
+}
+
+// ImageReport describes the processing of the image of a container
+type ImageReport struct {
+	Kind        string `json:"kind"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name"`
+	Container   string `json:"container"`
+	Image       string `json:"image"`
+	Action      string `json:"action"`
+	Destination string `json:"destination,omitempty"`
+	Digest      string `json:"digest,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Processor backs up the images of the workloads found in Kubernetes manifests
+// and rewrites the manifests to use the backup images
+type Processor struct {
+	regClient *registry.Client
+	rules     *config.ImageRuleSet
+	blacklist *config.NamespaceMatcher
+	whitelist *config.NamespaceMatcher
+	dryRun    bool
+	// copies the image, the registry client's Backup unless tested
+	backup func(string) (*registry.ImageInfo, error)
+	// results by image: each image is copied once
+	images map[string]ImageReport
+}
+
+// NewProcessorFromConfig returns a processor set from the program's config,
+// nothing is copied in dry-run mode but the manifests are rewritten to the planned backup images
+func NewProcessorFromConfig(dryRun bool) *Processor {
+	cfg := config.Current()
+	regClient := registry.NewClientFromConfig()
+	return &Processor{
+		regClient: regClient,
+		rules:     cfg.ImageRuleSet(),
+		blacklist: cfg.NamespaceBlacklist(),
+		whitelist: cfg.NamespaceWhitelist(),
+		dryRun:    dryRun,
+		backup:    regClient.Backup,
+		images:    map[string]ImageReport{},
+	}
+}
+
+// Process reads the YAML documents from r and writes them to w with the images of their workloads replaced by the backups.
+// The documents are written as read except for the image fields, the images which failed to be copied are left as is.
+// Fails on a document whose backed up images are not in block style YAML (e.g. JSON or flow style) as they can't be rewritten.
+func (p *Processor) Process(r io.Reader, w io.Writer) (*Report, error) {
+	report := &Report{DryRun: p.dryRun, Images: []ImageReport{}}
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(r))
+	first := true
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		images, err := p.processDocument(doc, report)
+		if err != nil {
+			return nil, err
+		}
+		if !first {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return nil, err
+			}
+		}
+		first = false
+		rewritten, err := rewrite(doc, images)
+		if err != nil {
+			return nil, err
+		}
+		out := append(bytes.TrimRight(rewritten, "\n"), '\n')
+		if _, err := w.Write(out); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// processDocument backs up the images of the workloads of the given YAML document.
+// Returns the backup images by container path (see containerPath).
+func (p *Processor) processDocument(doc []byte, report *Report) (map[string]string, error) {
+	obj := map[string]interface{}{}
+	if err := yaml.Unmarshal(doc, &obj); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	images := map[string]string{}
+	p.processObject(obj, report, images)
+	return images, nil
+}
+
+// processObject backs up the images of the given object, of its items if it's a List
+func (p *Processor) processObject(obj map[string]interface{}, report *Report, images map[string]string) {
+	walkContainers(obj, func(kind, namespace, name string, c map[string]interface{}) {
+		img, _ := c["image"].(string)
+		if img == "" {
+			return
+		}
+		result := p.processImage(img, namespace)
+		result.Kind, result.Namespace, result.Name = kind, namespace, name
+		result.Container, _ = c["name"].(string)
+		report.Images = append(report.Images, result)
+		if result.Action == ActionFailed {
+			report.Failed++
+		}
+		if result.Action == ActionBackedUp || result.Action == ActionPlanned {
+			images[containerPath(kind, namespace, name, c)] = result.Destination
+		}
+	})
+}
+
+// containerPath identifies a container of a document: the kind, the namespace and the name of its workload and its name
+func containerPath(kind, namespace, name string, container map[string]interface{}) string {
+	containerName, _ := container["name"].(string)
+	return fmt.Sprintf("%s/%s/%s/%s", kind, namespace, name, containerName)
+}
+
+// walkContainers calls fn with each container of the workload of the given object, of its items if it's a List
+func walkContainers(obj map[string]interface{}, fn func(kind, namespace, name string, container map[string]interface{})) {
+	kind, _ := obj["kind"].(string)
+	if items, isList := obj["items"].([]interface{}); isList && strings.HasSuffix(kind, "List") {
+		for _, item := range items {
+			if o, ok := item.(map[string]interface{}); ok {
+				walkContainers(o, fn)
+			}
+		}
+		return
+	}
+	path, supported := podSpecPaths[kind]
+	if !supported {
+		return
+	}
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	namespace, _ := metadata["namespace"].(string)
+	podSpec := field(obj, path)
+
+	for _, containers := range []string{"initContainers", "containers"} {
+		list, _ := podSpec[containers].([]interface{})
+		for _, item := range list {
+			if c, ok := item.(map[string]interface{}); ok {
+				fn(kind, namespace, name, c)
+			}
+		}
+	}
+}
+
+// processImage backs up the given image of a workload of the given namespace
+func (p *Processor) processImage(img, namespace string) ImageReport {
+	if namespace != "" && (p.blacklist.Matches(namespace) || (!p.whitelist.Empty() && !p.whitelist.Matches(namespace))) {
+		return ImageReport{Image: img, Action: ActionSkipped}
+	}
+	if p.regClient.Belongs(img) {
+		return ImageReport{Image: img, Action: ActionAlreadyBackedUp}
+	}
+	// backup images of the previous backup registries are moved whatever the rules
+	if action, _ := p.rules.Decide(img); action != config.ImageActionBackup && !p.regClient.Previous(img) {
+		return ImageReport{Image: img, Action: ActionSkipped}
+	}
+
+	if result, done := p.images[img]; done {
+		return result
+	}
+	result := ImageReport{Image: img}
+	if p.dryRun {
+		// nothing is sent to the backup registries, not even a health check: the copy with the lowest priority is planned
+		result.Action = ActionPlanned
+		result.Destination = p.regClient.Destinations(img)[0]
+	} else {
+		if info, err := p.backup(img); info == nil {
+			result.Action = ActionFailed
+			result.Error = err.Error()
+		} else {
+			result.Action = ActionBackedUp
+			result.Destination = p.regClient.Target(img, info.Replicas)
+			result.Digest = info.Digest
+			if err != nil {
+				// usable as copied to some of the backup registries
+				result.Error = err.Error()
+			}
+		}
+	}
+	p.images[img] = result
+	return result
+}
+
+// field returns the map at the given path of the object, nil if there is none
+func field(obj map[string]interface{}, path []string) map[string]interface{} {
+	for _, f := range path {
+		obj, _ = obj[f].(map[string]interface{})
+	}
+	return obj
+}
+
+// rewrite replaces the image fields of the given containers of the YAML document by their backup image,
+// images is keyed by container path. Only the image fields of these containers are rewritten: the other containers
+// and the image keys which don't belong to a container are left as is even if they have the same value.
+// Fails if the image of one of the given containers can't be rewritten.
+func rewrite(doc []byte, images map[string]string) ([]byte, error) {
+	if len(images) == 0 {
+		return doc, nil
+	}
+	lines := strings.Split(string(doc), "\n")
+
+	// each block style image field is marked with its line to find out which container it belongs to
+	marked := make([]string, len(lines))
+	copy(marked, lines)
+	markers := map[string]int{}
+	for i, line := range lines {
+		if m := imageLine.FindStringSubmatch(line); m != nil {
+			marker := fmt.Sprintf("image-clone-line-%d", i)
+			marked[i] = m[1] + m[2] + marker + m[4] + m[5]
+			markers[marker] = i
+		}
+	}
+	obj := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(strings.Join(marked, "\n")), &obj); err != nil {
+		return nil, fmt.Errorf("invalid manifest once rewritten: %v", err)
+	}
+
+	rewritten := map[string]bool{}
+	walkContainers(obj, func(kind, namespace, name string, c map[string]interface{}) {
+		path := containerPath(kind, namespace, name, c)
+		marker, _ := c["image"].(string)
+		i, isMarked := markers[marker]
+		dest, exists := images[path]
+		if !isMarked || !exists {
+			return
+		}
+		m := imageLine.FindStringSubmatch(lines[i])
+		lines[i] = m[1] + m[2] + dest + m[4] + m[5]
+		rewritten[path] = true
+	})
+	paths := []string{}
+	for path := range images {
+		if !rewritten[path] {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) > 0 {
+		sort.Strings(paths)
+		return nil, fmt.Errorf("failed to rewrite the image of the container %s: only block style YAML image fields are supported", paths[0])
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
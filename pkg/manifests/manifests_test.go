@@ -0,0 +1,428 @@
+package manifests
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/registry"
+)
+
+func TestProcess(t *testing.T) {
+	testCases := []struct {
+		name            string
+		input           string
+		dryRun          bool
+		expected        string
+		expectedActions []string
+		expectedFailed  int
+		expectedError   bool
+	}{
+		{
+			name: "Deployment",
+			input: `# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: ns
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: "busybox:1.31" # pinned
+      containers:
+      - name: app
+        image: nginx:1.17
+      - image: quay.io/org/sidecar:1
+        name: sidecar
+`,
+			expected: `# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: ns
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: "quay.io/org/busybox:1.31" # pinned
+      containers:
+      - name: app
+        image: quay.io/org/nginx:1.17
+      - image: quay.io/org/sidecar:1
+        name: sidecar
+`,
+			expectedActions: []string{ActionBackedUp, ActionBackedUp, ActionAlreadyBackedUp},
+		},
+		{
+			name: "Several documents",
+			input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  image: nginx:1.17
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: job
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: job
+            image: nginx:1.17
+`,
+			expected: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  image: nginx:1.17
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: job
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: job
+            image: quay.io/org/nginx:1.17
+`,
+			expectedActions: []string{ActionBackedUp},
+		},
+		{
+			name: "Skipped and failed",
+			input: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: pod
+    namespace: kube-system
+  spec:
+    containers:
+    - name: a
+      image: nginx:1.17
+- apiVersion: apps/v1
+  kind: DaemonSet
+  metadata:
+    name: ds
+  spec:
+    template:
+      spec:
+        containers:
+        - name: a
+          image: nvidia/driver:440
+        - name: b
+          image: broken:1
+`,
+			expected: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: pod
+    namespace: kube-system
+  spec:
+    containers:
+    - name: a
+      image: nginx:1.17
+- apiVersion: apps/v1
+  kind: DaemonSet
+  metadata:
+    name: ds
+  spec:
+    template:
+      spec:
+        containers:
+        - name: a
+          image: nvidia/driver:440
+        - name: b
+          image: broken:1
+`,
+			expectedActions: []string{ActionSkipped, ActionSkipped, ActionFailed},
+			expectedFailed:  1,
+		},
+		{
+			name: "Same image in a skipped namespace",
+			input: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: pod
+    namespace: kube-system
+  spec:
+    containers:
+    - name: a
+      image: nginx:1.17
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: pod
+    namespace: ns
+  spec:
+    containers:
+    - name: a
+      image: nginx:1.17
+`,
+			expected: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: pod
+    namespace: kube-system
+  spec:
+    containers:
+    - name: a
+      image: nginx:1.17
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: pod
+    namespace: ns
+  spec:
+    containers:
+    - name: a
+      image: quay.io/org/nginx:1.17
+`,
+			expectedActions: []string{ActionSkipped, ActionBackedUp},
+		},
+		{
+			name: "Image keys outside of the containers",
+			input: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    image: nginx:1.17
+spec:
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.17
+        env:
+        - name: IMAGE
+          value: nginx:1.17
+---
+apiVersion: example.com/v1
+kind: Build
+metadata:
+  name: build
+spec:
+  image: nginx:1.17
+`,
+			expected: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    image: nginx:1.17
+spec:
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      containers:
+      - name: app
+        image: quay.io/org/nginx:1.17
+        env:
+        - name: IMAGE
+          value: nginx:1.17
+---
+apiVersion: example.com/v1
+kind: Build
+metadata:
+  name: build
+spec:
+  image: nginx:1.17
+`,
+			expectedActions: []string{ActionBackedUp},
+		},
+		{
+			name:   "Dry-run",
+			dryRun: true,
+			input: `kind: Pod
+metadata:
+  name: pod
+spec:
+  containers:
+  - name: a
+    image: nginx:1.17
+`,
+			expected: `kind: Pod
+metadata:
+  name: pod
+spec:
+  containers:
+  - name: a
+    image: quay.io/org/nginx:1.17
+`,
+			expectedActions: []string{ActionPlanned},
+		},
+		{
+			name:          "JSON",
+			input:         `{"kind": "Pod", "metadata": {"name": "pod"}, "spec": {"containers": [{"name": "a", "image": "nginx:1.17"}]}}`,
+			expectedError: true,
+		},
+		{
+			name: "Flow style",
+			input: `kind: Pod
+metadata:
+  name: pod
+spec:
+  containers: [{name: a, image: nginx:1.17}]
+`,
+			expectedError: true,
+		},
+		{
+			name: "Image not backed up in flow style",
+			input: `kind: Pod
+metadata:
+  name: pod
+spec:
+  containers: [{name: a, image: quay.io/org/nginx:1.17}]
+`,
+			expected: `kind: Pod
+metadata:
+  name: pod
+spec:
+  containers: [{name: a, image: quay.io/org/nginx:1.17}]
+`,
+			expectedActions: []string{ActionAlreadyBackedUp},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			regClient := registry.NewClient("quay.io", "org", "", "", 0)
+			blacklist, _ := config.NewNamespaceMatcher([]string{"kube-system"})
+			rules, _ := config.NewImageRuleSet([]config.ImageRule{{Repository: "nvidia/*", Action: config.ImageActionSkip}})
+			copies := 0
+			p := &Processor{
+				regClient: regClient,
+				rules:     rules,
+				blacklist: blacklist,
+				whitelist: &config.NamespaceMatcher{},
+				dryRun:    tc.dryRun,
+				backup: func(img string) (*registry.ImageInfo, error) {
+					copies++
+					if strings.HasPrefix(img, "broken") {
+						return nil, errors.New("manifest unknown")
+					}
+					dest := regClient.Destination(img)
+					return &registry.ImageInfo{Name: dest, Digest: "sha256:a", Replicas: []string{dest}}, nil
+				},
+				images: map[string]ImageReport{},
+			}
+
+			output := &bytes.Buffer{}
+			report, err := p.Process(strings.NewReader(tc.input), output)
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("Expected the manifests not to be rewritten, got:\n%s", output.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if output.String() != tc.expected {
+				t.Errorf("Expected manifests:\n%s\ngot:\n%s", tc.expected, output.String())
+			}
+			actions := []string{}
+			for _, i := range report.Images {
+				actions = append(actions, i.Action)
+			}
+			if !reflect.DeepEqual(actions, tc.expectedActions) {
+				t.Errorf("Expected actions %v, got %v", tc.expectedActions, actions)
+			}
+			if report.Failed != tc.expectedFailed {
+				t.Errorf("Expected %d failures, got %d", tc.expectedFailed, report.Failed)
+			}
+			if tc.dryRun && copies > 0 {
+				t.Errorf("Expected no copy in dry-run mode, got %d", copies)
+			}
+		})
+	}
+}
+
+func TestProcessDryRunOffline(t *testing.T) {
+	// counts the connections to the backup registries
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	var connections int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&connections, 1)
+			conn.Close()
+		}
+	}()
+
+	cfg := &config.Config{
+		Registry:     listener.Addr().String(),
+		Organization: "org",
+		Username:     "user",
+		Password:     "pwd",
+		BackupRegistries: []config.BackupRegistry{
+			{Registry: "mirror." + listener.Addr().String(), Organization: "org", Username: "user", Password: "pwd", Priority: 1},
+		},
+	}
+	if err := config.Apply(cfg); err != nil {
+		t.Fatalf("Failed to apply the config: %v", err)
+	}
+
+	output := &bytes.Buffer{}
+	report, err := NewProcessorFromConfig(true).Process(strings.NewReader(`kind: Pod
+metadata:
+  name: pod
+spec:
+  containers:
+  - name: a
+    image: nginx:1.17
+`), output)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := listener.Addr().String() + "/org/nginx:1.17"
+	if len(report.Images) != 1 || report.Images[0].Destination != expected {
+		t.Errorf("Expected %s to be planned, got %v", expected, report.Images)
+	}
+	if n := atomic.LoadInt32(&connections); n > 0 {
+		t.Errorf("Expected no connection to the backup registries in dry-run mode, got %d", n)
+	}
+}
@@ -0,0 +1,268 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "image_clone"
+
+	// ResultSuccess is the result label value for the successful operations
+	ResultSuccess = "success"
+	// ResultFailure is the result label value for the failed operations
+	ResultFailure = "failure"
+	// ResultUnchanged is the result label value for the tag re-syncs finding the same image upstream
+	ResultUnchanged = "unchanged"
+	// ResultUpdated is the result label value for the tag re-syncs copying a new image
+	ResultUpdated = "updated"
+
+	// ReasonPacing is the reason label value for the workload updates deferred by the rollout pacing
+	ReasonPacing = "pacing"
+	// ReasonMaintenanceWindow is the reason label value for the workload updates deferred until a maintenance window
+	ReasonMaintenanceWindow = "maintenance_window"
+
+	// DirectionBackup is the direction label value for the workloads switched to the backup images
+	DirectionBackup = "backup"
+	// DirectionUpstream is the direction label value for the workloads switched back to the upstream images
+	DirectionUpstream = "upstream"
+)
+
+var (
+	copies = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "copies_total",
+		Help:      "Number of image copies to the backup registry by result.",
+	}, []string{"result"})
+
+	copyDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "copy_duration_seconds",
+		Help:      "Duration of the successful image copies to the backup registry.",
+		// 1s to ~1h
+		Buckets: prometheus.ExponentialBuckets(1, 2, 13),
+	})
+
+	copyBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "copy_bytes",
+		Help:      "Size of the images copied to the backup registry.",
+		// 1MiB to 8GiB
+		Buckets: prometheus.ExponentialBuckets(1<<20, 2, 14),
+	})
+
+	copiesInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "copies_in_flight",
+		Help:      "Number of image copies currently in progress.",
+	})
+
+	tagResyncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tag_resyncs_total",
+		Help:      "Number of re-syncs of the backed up mutable tags by result.",
+	}, []string{"result"})
+
+	gcDeletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gc_deletions_total",
+		Help:      "Number of unused backup images deleted by the garbage collection by result.",
+	}, []string{"result"})
+
+	gcReclaimableBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "gc_reclaimable_bytes",
+		Help:      "Size of the unused backup images found by the last garbage collection.",
+	})
+
+	workloadsMigrated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workloads_migrated_total",
+		Help:      "Number of workload updates to the backed up images by kind.",
+	}, []string{"kind"})
+
+	workloadsPending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workloads_pending",
+		Help:      "Number of workloads having images which are not backed up yet by kind.",
+	}, []string{"kind"})
+
+	workloadsOnPreviousRegistries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workloads_on_previous_registries",
+		Help:      "Number of workloads still using images of the previous backup registries by kind.",
+	}, []string{"kind"})
+
+	workloadsDeferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workloads_deferred_total",
+		Help:      "Number of deferred workload updates by kind and reason.",
+	}, []string{"kind", "reason"})
+
+	workloadsRolledBack = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workloads_rolled_back_total",
+		Help:      "Number of workloads reverted to their original images after pull failures by kind.",
+	}, []string{"kind"})
+
+	workloadReverts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workload_reverts_total",
+		Help:      "Number of image updates of workloads reverted by another tool by kind.",
+	}, []string{"kind"})
+
+	workloadsInConflict = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workloads_in_conflict",
+		Help:      "Number of workloads not updated anymore because another tool keeps reverting their updates by kind.",
+	}, []string{"kind"})
+
+	workloadFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workload_failovers_total",
+		Help:      "Number of workloads switched between the upstream and the backup images in failover mode by kind and direction.",
+	}, []string{"kind", "direction"})
+
+	imagesSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "images_skipped_total",
+		Help:      "Number of images skipped by the image rules by action.",
+	}, []string{"action"})
+
+	pending = &pendingSet{workloads: map[string]map[string]bool{}}
+	// workloads using images of the previous backup registries
+	onPreviousRegistries = &pendingSet{workloads: map[string]map[string]bool{}}
+	// workloads in conflict with another tool
+	inConflict = &pendingSet{workloads: map[string]map[string]bool{}}
+)
+
+func init() {
+	ctrmetrics.Registry.MustRegister(
+		copies,
+		copyDuration,
+		copyBytes,
+		copiesInFlight,
+		tagResyncs,
+		gcDeletions,
+		gcReclaimableBytes,
+		workloadsMigrated,
+		workloadsPending,
+		workloadsOnPreviousRegistries,
+		workloadsDeferred,
+		workloadsRolledBack,
+		workloadReverts,
+		workloadsInConflict,
+		workloadFailovers,
+		imagesSkipped,
+	)
+}
+
+// CopyStarted records the start of an image copy
+func CopyStarted() {
+	copiesInFlight.Inc()
+}
+
+// CopyFinished records the end of an image copy which took the given number of seconds
+func CopyFinished(seconds float64, err error) {
+	copiesInFlight.Dec()
+	if err != nil {
+		copies.WithLabelValues(ResultFailure).Inc()
+		return
+	}
+	copies.WithLabelValues(ResultSuccess).Inc()
+	copyDuration.Observe(seconds)
+}
+
+// CopiedBytes records the size of a copied image
+func CopiedBytes(size int64) {
+	copyBytes.Observe(float64(size))
+}
+
+// TagResynced records a re-sync of a mutable tag with the given result
+func TagResynced(result string) {
+	tagResyncs.WithLabelValues(result).Inc()
+}
+
+// GCDeleted records a deletion of an unused backup image by the garbage collection
+func GCDeleted(err error) {
+	if err != nil {
+		gcDeletions.WithLabelValues(ResultFailure).Inc()
+		return
+	}
+	gcDeletions.WithLabelValues(ResultSuccess).Inc()
+}
+
+// GCReclaimable records the size of the unused backup images found by the garbage collection
+func GCReclaimable(size int64) {
+	gcReclaimableBytes.Set(float64(size))
+}
+
+// WorkloadMigrated records an update of a workload to the backed up images
+func WorkloadMigrated(kind string) {
+	workloadsMigrated.WithLabelValues(kind).Inc()
+}
+
+// WorkloadPending records whether the given workload still has images which are not backed up
+func WorkloadPending(kind, key string, isPending bool) {
+	workloadsPending.WithLabelValues(kind).Set(float64(pending.set(kind, key, isPending)))
+}
+
+// WorkloadOnPreviousRegistry records whether the given workload still uses images of the previous backup registries
+func WorkloadOnPreviousRegistry(kind, key string, onPrevious bool) {
+	workloadsOnPreviousRegistries.WithLabelValues(kind).Set(float64(onPreviousRegistries.set(kind, key, onPrevious)))
+}
+
+// WorkloadDeferred records a workload update deferred for the given reason
+func WorkloadDeferred(kind, reason string) {
+	workloadsDeferred.WithLabelValues(kind, reason).Inc()
+}
+
+// WorkloadRolledBack records a workload reverted to its original images
+func WorkloadRolledBack(kind string) {
+	workloadsRolledBack.WithLabelValues(kind).Inc()
+}
+
+// WorkloadReverted records an image update of a workload reverted by another tool
+func WorkloadReverted(kind string) {
+	workloadReverts.WithLabelValues(kind).Inc()
+}
+
+// WorkloadInConflict records whether the given workload is in conflict with another tool
+func WorkloadInConflict(kind, key string, conflict bool) {
+	workloadsInConflict.WithLabelValues(kind).Set(float64(inConflict.set(kind, key, conflict)))
+}
+
+// WorkloadFailedOver records a workload switched to the backup images or back to the upstream ones
+func WorkloadFailedOver(kind, direction string) {
+	workloadFailovers.WithLabelValues(kind, direction).Inc()
+}
+
+// ImageSkipped records an image skipped by the given image rule action
+func ImageSkipped(action string) {
+	imagesSkipped.WithLabelValues(action).Inc()
+}
+
+// pendingSet keeps track of the pending workloads
+// to be able to report their number without double counting
+type pendingSet struct {
+	sync.Mutex
+	workloads map[string]map[string]bool
+}
+
+// set adds or removes the workload from the set and returns the number of pending workloads of the given kind
+func (s *pendingSet) set(kind, key string, isPending bool) int {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, exists := s.workloads[kind]; !exists {
+		s.workloads[kind] = map[string]bool{}
+	}
+	if isPending {
+		s.workloads[kind][key] = true
+	} else {
+		delete(s.workloads[kind], key)
+	}
+	return len(s.workloads[kind])
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestPendingSet(t *testing.T) {
+	testCases := []struct {
+		name     string
+		kind     string
+		key      string
+		pending  bool
+		expected int
+	}{
+		{
+			name:     "First pending",
+			kind:     "Deployment",
+			key:      "ns/one",
+			pending:  true,
+			expected: 1,
+		},
+		{
+			name:     "Same pending again",
+			kind:     "Deployment",
+			key:      "ns/one",
+			pending:  true,
+			expected: 1,
+		},
+		{
+			name:     "Second pending",
+			kind:     "Deployment",
+			key:      "ns/two",
+			pending:  true,
+			expected: 2,
+		},
+		{
+			name:     "Other kind",
+			kind:     "DaemonSet",
+			key:      "ns/one",
+			pending:  true,
+			expected: 1,
+		},
+		{
+			name:     "No longer pending",
+			kind:     "Deployment",
+			key:      "ns/one",
+			pending:  false,
+			expected: 1,
+		},
+		{
+			name:     "Unknown no longer pending",
+			kind:     "Deployment",
+			key:      "ns/unknown",
+			pending:  false,
+			expected: 1,
+		},
+	}
+
+	set := &pendingSet{workloads: map[string]map[string]bool{}}
+	// test cases are sequential: each one depends on the previous ones
+	for _, tc := range testCases {
+		output := set.set(tc.kind, tc.key, tc.pending)
+		if output != tc.expected {
+			t.Errorf("Test case %q: expected %d, got %d", tc.name, tc.expected, output)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"image-clone-controller/pkg/image"
+)
+
+const (
+	defaultAPITimeout = 10 * time.Second
+)
+
+var (
+	// ErrUnauthorized is returned when the registry rejects the credentials
+	ErrUnauthorized = errors.New("credentials rejected by the registry")
+
+	manifestMediaTypes = []string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+	}
+)
+
+// Ping checks that the backup registry is reachable and accepts the client's credentials
+func (c *Client) Ping() error {
+	c = c.current()
+	resp, err := c.httpClient.Get(c.apiEndpoint + "/v2/")
+	if err != nil {
+		return fmt.Errorf("registry unreachable: %v", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// anonymous access allowed
+		return nil
+	case http.StatusUnauthorized:
+		return c.authenticate(resp.Header.Get("WWW-Authenticate"))
+	default:
+		return fmt.Errorf("unexpected status from the registry: %s", resp.Status)
+	}
+}
+
+// authenticate checks the credentials against the authentication scheme from the given challenge
+func (c *Client) authenticate(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+
+	var req *http.Request
+	var err error
+	switch strings.ToLower(scheme) {
+	case "basic":
+		req, err = http.NewRequest(http.MethodGet, c.apiEndpoint+"/v2/", nil)
+	case "bearer":
+		req, err = c.tokenRequest(params, "")
+	default:
+		return fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("authentication failed: %v", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	default:
+		return fmt.Errorf("unexpected status from the authentication: %s", resp.Status)
+	}
+}
+
+// tokenRequest returns the request of a bearer token for the given scope, empty for no particular scope,
+// to the realm from the challenge parameters
+func (c *Client) tokenRequest(params map[string]string, scope string) (*http.Request, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return nil, fmt.Errorf("invalid token realm %q in the challenge", params["realm"])
+	}
+	q := realm.Query()
+	if service, exists := params["service"]; exists {
+		q.Set("service", service)
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+	realm.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	return req, nil
+}
+
+// token returns a bearer token for the given scope from the realm of the challenge parameters
+func (c *Client) token(params map[string]string, scope string) (string, error) {
+	req, err := c.tokenRequest(params, scope)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("authentication failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", ErrUnauthorized
+	default:
+		return "", fmt.Errorf("unexpected status from the authentication: %s", resp.Status)
+	}
+	body := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response: %v", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// do sends a request to the registry API authenticated with the client's credentials for the given token scope
+func (c *Client) do(method, path, scope string) (*http.Response, error) {
+	req, err := c.newRequest(method, path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	// authenticating as the registry asks for
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	if req, err = c.newRequest(method, path); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(scheme) {
+	case "basic":
+		req.SetBasicAuth(c.username, c.password)
+	case "bearer":
+		token, err := c.token(params, scope)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	default:
+		return nil, fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+	return c.httpClient.Do(req)
+}
+
+// DeleteImage deletes the manifest with the given digest from the repository of the given backup image,
+// all the tags of the manifest are deleted with it. Deleting a manifest which doesn't exist is not an error
+// as long as the given image (tag or digest) doesn't exist either.
+func (c *Client) DeleteImage(fullName, digest string) error {
+	c = c.current().owner(fullName)
+	repository := image.Parse(fullName).Repository
+	resp, err := c.do(http.MethodDelete, fmt.Sprintf("/v2/%s/manifests/%s", repository, digest), fmt.Sprintf("repository:%s:delete", repository))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		// e.g. the tag points to another manifest
+		exists, err := c.imageExists(fullName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("manifest %s not found while %s still exists", digest, fullName)
+		}
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	default:
+		return fmt.Errorf("unexpected status from the registry: %s", resp.Status)
+	}
+}
+
+// ImageExists returns true if the given backup image (tag or digest) exists in the backup registry it belongs to
+func (c *Client) ImageExists(fullName string) (bool, error) {
+	return c.current().owner(fullName).imageExists(fullName)
+}
+
+// imageExists returns true if the given image exists in the client's own registry
+func (c *Client) imageExists(fullName string) (bool, error) {
+	ref := image.Parse(fullName)
+	reference := ref.Tag
+	if ref.Digest != "" {
+		reference = ref.Digest
+	}
+	resp, err := c.do(http.MethodHead, fmt.Sprintf("/v2/%s/manifests/%s", ref.Repository, reference), fmt.Sprintf("repository:%s:pull", ref.Repository))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, ErrUnauthorized
+	default:
+		return false, fmt.Errorf("unexpected status from the registry: %s", resp.Status)
+	}
+}
+
+// newRequest returns a request to the registry API accepting all the manifest types:
+// a registry may answer 404 for a manifest it can't convert to the accepted types
+func (c *Client) newRequest(method, path string) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.apiEndpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	return req, nil
+}
+
+// parseChallenge splits WWW-Authenticate header value into the scheme and its parameters.
+// E.g.: Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	challenge = strings.TrimSpace(challenge)
+	i := strings.Index(challenge, " ")
+	if i < 0 {
+		return challenge, params
+	}
+
+	scheme := challenge[:i]
+	for _, p := range strings.Split(challenge[i+1:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	return scheme, params
+}
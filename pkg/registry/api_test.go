@@ -0,0 +1,236 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	testCases := []struct {
+		name          string
+		scheme        string
+		username      string
+		password      string
+		expectedError bool
+	}{
+		{
+			name:   "Anonymous",
+			scheme: "",
+		},
+		{
+			name:     "Basic",
+			scheme:   "Basic",
+			username: "user",
+			password: "pwd",
+		},
+		{
+			name:          "Basic rejected",
+			scheme:        "Basic",
+			username:      "user",
+			password:      "wrong",
+			expectedError: true,
+		},
+		{
+			name:     "Bearer",
+			scheme:   "Bearer",
+			username: "user",
+			password: "pwd",
+		},
+		{
+			name:          "Bearer rejected",
+			scheme:        "Bearer",
+			username:      "user",
+			password:      "wrong",
+			expectedError: true,
+		},
+		{
+			name:          "Unsupported",
+			scheme:        "Negotiate",
+			username:      "user",
+			password:      "pwd",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestRegistry(tc.scheme)
+			defer srv.Close()
+
+			cli := NewClient("localhost", "org", tc.username, tc.password, 0)
+			cli.apiEndpoint = srv.URL
+			err := cli.Ping()
+			if err != nil {
+				if !tc.expectedError {
+					t.Errorf("Got not expected error: %v", err)
+				}
+			} else {
+				if tc.expectedError {
+					t.Error("Got no error while one is expected")
+				}
+			}
+		})
+	}
+}
+
+func TestPingUnreachable(t *testing.T) {
+	srv := newTestRegistry("")
+	srv.Close()
+
+	cli := NewClient("localhost", "org", "user", "pwd", 0)
+	cli.apiEndpoint = srv.URL
+	if err := cli.Ping(); err == nil {
+		t.Error("Got no error while one is expected")
+	}
+}
+
+func TestDeleteImage(t *testing.T) {
+	testCases := []struct {
+		name          string
+		scheme        string
+		password      string
+		image         string
+		digest        string
+		expectedError bool
+	}{
+		{
+			name:     "Basic",
+			scheme:   "Basic",
+			password: "pwd",
+		},
+		{
+			name:     "Bearer",
+			scheme:   "Bearer",
+			password: "pwd",
+		},
+		{
+			name:          "Bearer rejected",
+			scheme:        "Bearer",
+			password:      "wrong",
+			expectedError: true,
+		},
+		{
+			name:     "Already deleted",
+			scheme:   "Basic",
+			password: "pwd",
+			image:    "localhost/org/nginx:1.18",
+			digest:   unknownDigest,
+		},
+		{
+			name:          "Tag moved to another manifest",
+			scheme:        "Basic",
+			password:      "pwd",
+			digest:        unknownDigest,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newTestRegistry(tc.scheme)
+			defer srv.Close()
+
+			cli := NewClient("localhost", "org", "user", tc.password, 0)
+			cli.apiEndpoint = srv.URL
+			img, digest := "localhost/org/nginx:1.19", "sha256:0123456789abcdef"
+			if tc.image != "" {
+				img = tc.image
+			}
+			if tc.digest != "" {
+				digest = tc.digest
+			}
+			err := cli.DeleteImage(img, digest)
+			if tc.expectedError != (err != nil) {
+				t.Errorf("Expected error: %v, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          string
+		expectedScheme string
+		expectedParams map[string]string
+	}{
+		{
+			name:           "Bearer",
+			input:          `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`,
+			expectedScheme: "Bearer",
+			expectedParams: map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io"},
+		},
+		{
+			name:           "Basic",
+			input:          `Basic realm="Registry Realm"`,
+			expectedScheme: "Basic",
+			expectedParams: map[string]string{"realm": "Registry Realm"},
+		},
+		{
+			name:           "No params",
+			input:          "Basic",
+			expectedScheme: "Basic",
+			expectedParams: map[string]string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme, params := parseChallenge(tc.input)
+			if scheme != tc.expectedScheme {
+				t.Errorf("Expected scheme %q, got %q", tc.expectedScheme, scheme)
+			}
+			if !reflect.DeepEqual(params, tc.expectedParams) {
+				t.Errorf("Expected params %v, got %v", tc.expectedParams, params)
+			}
+		})
+	}
+}
+
+// unknownDigest is a digest of no manifest of the test registry
+const unknownDigest = "sha256:fedcba9876543210"
+
+// newTestRegistry returns a fake registry accepting user:pwd credentials with the given authentication scheme
+func newTestRegistry(scheme string) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	authorized := func(r *http.Request) bool {
+		u, p, ok := r.BasicAuth()
+		return ok && u == "user" && p == "pwd"
+	}
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case scheme == "":
+		case scheme == "Basic" && authorized(r):
+		case scheme == "Bearer" && r.Header.Get("Authorization") == "Bearer test-token":
+		case scheme == "Bearer":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Header().Set("WWW-Authenticate", scheme+` realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/manifests/"+unknownDigest):
+			// only the 1.19 tag and its manifest exist
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodHead && !strings.HasSuffix(r.URL.Path, "/manifests/1.19"):
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("service") != "test" || !authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token":"test-token"}`)
+	})
+	return srv
+}
@@ -2,19 +2,26 @@ package registry
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os/exec"
 	"strings"
 	"time"
 
 	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/metrics"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 const (
 	defaultSkopeoTransport = "docker://"
+	defaultInspectTimeout  = 60
 )
 
+var log = logf.Log.WithName("registry_client")
+
 var registryAliases = map[string][]string{
 	"registry-1.docker.io": {
 		"docker.io",
@@ -33,6 +40,17 @@ type Client struct {
 	password           string
 	copyTimeoutSeconds int
 	transport          string
+	// registry API is used for the checks which don't need skopeo
+	apiEndpoint string
+	httpClient  *http.Client
+	// client set from the config in effect, nil if the client doesn't follow the program's config
+	live *config.Derived
+	// priority of the registry among the backup destinations, the lowest first
+	priority int
+	// additional registries the images are backed up to
+	mirrors []*Client
+	// previous backup registries with their organization (registry/organization)
+	previous []string
 }
 
 // NewClient returns new registry client
@@ -44,24 +62,74 @@ func NewClient(registry, org, username, password string, timeout int) *Client {
 		password:           password,
 		copyTimeoutSeconds: timeout,
 		transport:          defaultSkopeoTransport,
+		apiEndpoint:        "https://" + registry,
+		httpClient:         &http.Client{Timeout: defaultAPITimeout},
 	}
 }
 
-// NewClientFromConfig returns new registry client set from the program's config
+// NewClientFromConfig returns new registry client set from the program's config.
+// The client follows the config changes: each operation uses the config in effect.
 func NewClientFromConfig() *Client {
-	return &Client{
-		registry:           config.GlobalConfig.Registry,
-		organization:       config.GlobalConfig.Organization,
-		username:           config.GlobalConfig.Username,
-		password:           config.GlobalConfig.Password,
-		copyTimeoutSeconds: config.GlobalConfig.ImageCopyTimeoutSeconds,
+	httpClient := &http.Client{Timeout: defaultAPITimeout}
+	c := newClientFromConfig(config.Current(), httpClient)
+	c.live = config.NewDerived(func(cfg *config.Config) interface{} {
+		return newClientFromConfig(cfg, httpClient)
+	})
+	return c
+}
+
+// newClientFromConfig returns new registry client set from the given config
+func newClientFromConfig(cfg *config.Config, httpClient *http.Client) *Client {
+	c := &Client{
+		registry:           cfg.Registry,
+		organization:       cfg.Organization,
+		username:           cfg.Username,
+		password:           cfg.Password,
+		copyTimeoutSeconds: cfg.ImageCopyTimeoutSeconds,
 		transport:          defaultSkopeoTransport,
+		apiEndpoint:        "https://" + cfg.Registry,
+		httpClient:         httpClient,
+		previous:           cfg.MigrateFrom,
+	}
+	for _, r := range cfg.BackupRegistries {
+		c.mirrors = append(c.mirrors, &Client{
+			registry:           r.Registry,
+			organization:       r.Organization,
+			username:           r.Username,
+			password:           r.Password,
+			copyTimeoutSeconds: cfg.ImageCopyTimeoutSeconds,
+			transport:          defaultSkopeoTransport,
+			apiEndpoint:        "https://" + r.Registry,
+			httpClient:         httpClient,
+			priority:           r.Priority,
+			previous:           cfg.MigrateFrom,
+		})
 	}
+	return c
+}
+
+// current returns the client to use for an operation:
+// the client itself or, if it follows the program's config, the client set from the config in effect
+func (c *Client) current() *Client {
+	if c.live == nil {
+		return c
+	}
+	return c.live.Get().(*Client)
 }
 
 // Belongs returns true if given full image name
-// belongs to the registry the client is currently using
+// belongs to one of the registries the client is currently using
 func (c *Client) Belongs(fullName string) bool {
+	for _, d := range c.current().destinations() {
+		if d.belongs(fullName) {
+			return true
+		}
+	}
+	return false
+}
+
+// belongs returns true if given full image name belongs to the client's own registry
+func (c *Client) belongs(fullName string) bool {
 	fullName = strings.TrimSpace(fullName)
 	substr := strings.Split(fullName, "/")
 	if len(substr) < 2 {
@@ -70,7 +138,7 @@ func (c *Client) Belongs(fullName string) bool {
 		return false
 	}
 
-	for _, r := range registryAliases[c.registry] {
+	for _, r := range c.aliases() {
 		if substr[0] == r {
 			if substr[1] == c.organization {
 				return true
@@ -81,20 +149,165 @@ func (c *Client) Belongs(fullName string) bool {
 	return false
 }
 
-// Backup pulls the given image to the backup registry.
-// New image full name is returned.
-func (c *Client) Backup(fullName string) (string, error) {
+// ImageInfo describes an image stored in a registry
+type ImageInfo struct {
+	// Name is the full name of the image
+	Name string
+	// Digest of the image manifest
+	Digest string
+	// Size of the image: sum of the config and the layer sizes, 0 if unknown (manifest list)
+	Size int64
+	// Replicas are the full names of the copies of the image in all the backup registries
+	Replicas []string
+}
+
+// Backup pulls the given image to all the backup registries.
+// Backup image in the registry with the lowest priority is returned, its digest and size are not set
+// if the inspection of the copied image failed. When some of the copies failed, both the image
+// and the error are returned: the image lists the successful copies in its Replicas.
+func (c *Client) Backup(fullName string) (*ImageInfo, error) {
+	var info *ImageInfo
+	failures := []string{}
+	var lastErr error
+	for _, d := range c.current().destinations() {
+		copied, err := d.backup(fullName)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", d.registry, err))
+			lastErr = err
+			continue
+		}
+		if info == nil {
+			info = copied
+		}
+		info.Replicas = append(info.Replicas, copied.Name)
+	}
+	switch {
+	case len(failures) == 0:
+		return info, nil
+	case info == nil && len(failures) == 1:
+		return nil, lastErr
+	default:
+		return info, fmt.Errorf("failed to copy the image to %s", strings.Join(failures, ", "))
+	}
+}
+
+// backup pulls the given image to the client's own registry
+func (c *Client) backup(fullName string) (*ImageInfo, error) {
 	newName := c.newFullName(fullName)
-	if err := c.copyImage(fullName, newName); err != nil {
-		return "", err
+
+	metrics.CopyStarted()
+	start := time.Now()
+	err := c.copyImage(fullName, newName)
+	metrics.CopyFinished(time.Since(start).Seconds(), err)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := c.Inspect(newName)
+	if err != nil {
+		log.Error(err, "Failed to inspect the backed up image", "Image", newName)
+		return &ImageInfo{Name: newName}, nil
+	}
+	if info.Size > 0 {
+		metrics.CopiedBytes(info.Size)
+	}
+	return info, nil
+}
+
+// Inspect returns the digest and the size of the given image from the backup registry it belongs to
+func (c *Client) Inspect(fullName string) (*ImageInfo, error) {
+	c = c.current().owner(fullName)
+	return inspect(c.skopeoInspectCmd(fullName), fullName)
+}
+
+// InspectSource returns the digest and the size of the given image from its source registry,
+// the image is accessed anonymously as the backup registry credentials don't apply there
+func (c *Client) InspectSource(fullName string) (*ImageInfo, error) {
+	return inspect(c.skopeoInspectSourceCmd(fullName), fullName)
+}
+
+// inspect runs the given skopeo inspect command for the given image
+func inspect(cmdStr, fullName string) (*ImageInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultInspectTimeout*time.Second)
+	defer cancel()
+	log.V(1).Info("Command", "cmd", cmdStr)
+	cmdSl := strings.Split(cmdStr, " ")
+	raw, err := exec.CommandContext(ctx, cmdSl[0], cmdSl[1:]...).Output()
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseManifest(raw)
+	if err != nil {
+		return nil, err
 	}
-	return newName, nil
+	info.Name = fullName
+	return info, nil
+}
+
+// Destination returns the full name of the backup of the given image in the primary backup registry
+func (c *Client) Destination(fullName string) string {
+	return c.current().newFullName(fullName)
 }
 
 // newFullName compacts the given image name to a single repository
-// and prepends the backup destination, tag remains untouched
+// and prepends the backup destination, tag remains untouched.
+// The backup images of the previous backup registries keep their name.
 func (c *Client) newFullName(fullName string) string {
-	return fmt.Sprintf("%s/%s/%s", registryAliases[c.registry][0], c.organization, strings.ReplaceAll(strings.TrimSpace(fullName), "/", "-"))
+	name, previous := c.previousBackup(fullName)
+	if !previous {
+		name = strings.ReplaceAll(strings.TrimSpace(fullName), "/", "-")
+	}
+	return fmt.Sprintf("%s/%s/%s", c.aliases()[0], c.organization, name)
+}
+
+// Previous returns true if the given full image name is a backup image of one of the previous backup registries
+func (c *Client) Previous(fullName string) bool {
+	_, previous := c.current().previousBackup(fullName)
+	return previous
+}
+
+// previousBackup returns the name of the given image without registry and organization
+// if it's a backup image of one of the previous backup registries
+func (c *Client) previousBackup(fullName string) (string, bool) {
+	substr := strings.Split(strings.TrimSpace(fullName), "/")
+	if len(substr) != 3 {
+		// backup images are compacted to a single repository
+		return "", false
+	}
+	for _, p := range c.previous {
+		prev := strings.SplitN(p, "/", 2)
+		if len(prev) == 2 && sameRegistry(substr[0], prev[0]) && substr[1] == prev[1] {
+			return substr[2], true
+		}
+	}
+	return "", false
+}
+
+// aliases returns the names the backup registry is referred by in the images, the first one is used for the backups
+func (c *Client) aliases() []string {
+	if aliases, exists := registryAliases[c.registry]; exists {
+		return aliases
+	}
+	return []string{c.registry}
+}
+
+// sameRegistry returns true if both names refer to the same registry
+func sameRegistry(a, b string) bool {
+	if a == b {
+		return true
+	}
+	for _, aliases := range registryAliases {
+		matches := 0
+		for _, alias := range aliases {
+			if alias == a || alias == b {
+				matches++
+			}
+		}
+		if matches == 2 {
+			return true
+		}
+	}
+	return false
 }
 
 // copyImage mirrors the image from source to destination
@@ -102,7 +315,7 @@ func (c *Client) copyImage(src, dst string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.copyTimeoutSeconds)*time.Second)
 	defer cancel()
 	cmdStr := c.skopeoCopyCmd(src, dst)
-	logf.Log.WithName("registry_client").V(1).Info("Command", cmdStr)
+	log.V(1).Info("Command", "cmd", cmdStr)
 	cmdSl := strings.Split(cmdStr, " ")
 	return exec.CommandContext(ctx, cmdSl[0], cmdSl[1:]...).Run()
 }
@@ -114,3 +327,42 @@ func (c *Client) skopeoCopyCmd(src, dst string) string {
 	dst = fmt.Sprintf("%s%s", c.transport, dst)
 	return fmt.Sprintf("skopeo copy --dest-creds %s %s %s", cred, src, dst)
 }
+
+// skopeoInspectCmd constructs skopeo inspect command which outputs the raw manifest
+func (c *Client) skopeoInspectCmd(fullName string) string {
+	cred := fmt.Sprintf("%s:%s", c.username, c.password)
+	return fmt.Sprintf("skopeo inspect --raw --creds %s %s%s", cred, c.transport, fullName)
+}
+
+// skopeoInspectSourceCmd constructs skopeo inspect command which outputs the raw manifest without credentials
+func (c *Client) skopeoInspectSourceCmd(fullName string) string {
+	return fmt.Sprintf("skopeo inspect --raw %s%s", c.transport, fullName)
+}
+
+// manifest is the part of an image manifest needed to compute the image size
+type manifest struct {
+	Config struct {
+		Size int64 `json:"size"`
+	} `json:"config"`
+	Layers []struct {
+		Size int64 `json:"size"`
+	} `json:"layers"`
+}
+
+// parseManifest computes the digest and the size of the image from its raw manifest
+func parseManifest(raw []byte) (*ImageInfo, error) {
+	m := &manifest{}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, err
+	}
+
+	info := &ImageInfo{
+		Digest: fmt.Sprintf("sha256:%x", sha256.Sum256(raw)),
+		Size:   m.Config.Size,
+	}
+	// a manifest list has neither config nor layers: the size of the platform images is unknown
+	for _, l := range m.Layers {
+		info.Size += l.Size
+	}
+	return info, nil
+}
@@ -47,6 +47,12 @@ func TestBelongs(t *testing.T) {
 			input:    "docker.io/coredns/coredns:1.3.1",
 			expected: false,
 		},
+		{
+			name:     "Registry without aliases",
+			cli:      NewClient("registry.corp.internal", "team-a", "", "", 0),
+			input:    "registry.corp.internal/team-a/nginx:1.17",
+			expected: true,
+		},
 		{
 			name:     "Rubbish",
 			cli:      NewClient("registry-1.docker.io", "alebedev87", "", "", 0),
@@ -108,12 +114,30 @@ func TestNewFullName(t *testing.T) {
 			input:    "openvpn",
 			expected: "docker.io/alebedev87/openvpn",
 		},
+		{
+			name:     "Registry without aliases",
+			cli:      NewClient("registry.corp.internal", "team-a", "", "", 0),
+			input:    "nginx:1.17",
+			expected: "registry.corp.internal/team-a/nginx:1.17",
+		},
 		{
 			name:     "Spaces removed",
 			cli:      NewClient("quay.io", "alebedev87", "", "", 0),
 			input:    "  quay.io/coredns:1.3.1  ",
 			expected: "quay.io/alebedev87/quay.io-coredns:1.3.1",
 		},
+		{
+			name:     "Previous backup registry",
+			cli:      &Client{registry: "harbor.corp.internal", organization: "backup", previous: []string{"registry-1.docker.io/alebedev87"}},
+			input:    "docker.io/alebedev87/quay.io-coredns-coredns:1.3.1",
+			expected: "harbor.corp.internal/backup/quay.io-coredns-coredns:1.3.1",
+		},
+		{
+			name:     "Other organization of the previous backup registry",
+			cli:      &Client{registry: "harbor.corp.internal", organization: "backup", previous: []string{"docker.io/alebedev87"}},
+			input:    "docker.io/coredns/coredns:1.3.1",
+			expected: "harbor.corp.internal/backup/docker.io-coredns-coredns:1.3.1",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -153,3 +177,83 @@ func TestSkopeoCopyCmd(t *testing.T) {
 		})
 	}
 }
+
+func TestSkopeoInspectCmd(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cli      *Client
+		input    string
+		expected string
+	}{
+		{
+			name:     "Nominal",
+			cli:      NewClient("", "", "here", "there", 0),
+			input:    "docker.io/alebedev87/coredns:1.3.1",
+			expected: "skopeo inspect --raw --creds here:there docker://docker.io/alebedev87/coredns:1.3.1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := tc.cli.skopeoInspectCmd(tc.input)
+			if output != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestSkopeoInspectSourceCmd(t *testing.T) {
+	cli := NewClient("", "", "here", "there", 0)
+	expected := "skopeo inspect --raw docker://docker.io/alebedev87/coredns:1.3.1"
+	if output := cli.skopeoInspectSourceCmd("docker.io/alebedev87/coredns:1.3.1"); output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	testCases := []struct {
+		name          string
+		input         string
+		expected      *ImageInfo
+		expectedError bool
+	}{
+		{
+			name:     "Image manifest",
+			input:    `{"schemaVersion":2,"config":{"size":100},"layers":[{"size":1000},{"size":10000}]}`,
+			expected: &ImageInfo{Digest: "sha256:55e58a40e206f25b2760ab05cef9a1d3f7f75d446ba896ca5603e01287a5066b", Size: 11100},
+		},
+		{
+			name:     "Manifest list",
+			input:    `{"schemaVersion":2,"manifests":[{"size":500},{"size":600}]}`,
+			expected: &ImageInfo{Digest: "sha256:30d2de75ac6e22953e154a2cbdf363dca31c71de04f7f4435ee37b20e42c6572", Size: 0},
+		},
+		{
+			name:          "Rubbish",
+			input:         "not a manifest",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := parseManifest([]byte(tc.input))
+			if err != nil {
+				if !tc.expectedError {
+					t.Errorf("Got not expected error: %v", err)
+				}
+				return
+			}
+			if tc.expectedError {
+				t.Error("Got no error while one is expected")
+				return
+			}
+			if output.Size != tc.expected.Size {
+				t.Errorf("Expected size %d, got %d", tc.expected.Size, output.Size)
+			}
+			if output.Digest != tc.expected.Digest {
+				t.Errorf("Expected digest %q, got %q", tc.expected.Digest, output.Digest)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// health of the backup registries is checked again after this delay
+	healthCheckTTL = 30 * time.Second
+)
+
+var (
+	// health checks of the backup registries by API endpoint, shared by all the clients
+	healthChecks     = map[string]healthCheck{}
+	healthChecksLock sync.Mutex
+)
+
+type healthCheck struct {
+	healthy bool
+	time    time.Time
+}
+
+// destinations returns the client of each backup registry, sorted by priority.
+// The primary registry comes first among the registries of the same priority.
+func (c *Client) destinations() []*Client {
+	all := append([]*Client{c}, c.mirrors...)
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].priority < all[j].priority
+	})
+	return all
+}
+
+// owner returns the client of the backup registry the given image belongs to, the client itself if none
+func (c *Client) owner(fullName string) *Client {
+	for _, d := range c.destinations() {
+		if d.belongs(fullName) {
+			return d
+		}
+	}
+	return c
+}
+
+// Destinations returns the full names of the backups of the given image in all the backup registries, sorted by priority
+func (c *Client) Destinations(fullName string) []string {
+	names := []string{}
+	for _, d := range c.current().destinations() {
+		names = append(names, d.newFullName(fullName))
+	}
+	return names
+}
+
+// Target returns the backup image of the given image the workloads should use among the given copies:
+// the copy in the healthy registry with the lowest priority, the copy with the lowest priority if no registry is healthy.
+// Empty if none of the given copies is in the client's backup registries.
+func (c *Client) Target(fullName string, copies []string) string {
+	copied := map[string]bool{}
+	for _, name := range copies {
+		copied[name] = true
+	}
+	candidates := []*Client{}
+	for _, d := range c.current().destinations() {
+		if copied[d.newFullName(fullName)] {
+			candidates = append(candidates, d)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return ""
+	case 1:
+		// no choice, no need to check the health
+		return candidates[0].newFullName(fullName)
+	}
+	for _, d := range candidates {
+		if d.healthy() {
+			return d.newFullName(fullName)
+		}
+	}
+	return candidates[0].newFullName(fullName)
+}
+
+// healthy returns true if the client's registry answered the last health check, done at most every healthCheckTTL
+func (c *Client) healthy() bool {
+	key := c.apiEndpoint + "/" + c.username
+	healthChecksLock.Lock()
+	h, checked := healthChecks[key]
+	healthChecksLock.Unlock()
+	if checked && time.Since(h.time) < healthCheckTTL {
+		return h.healthy
+	}
+
+	err := c.Ping()
+	if err != nil {
+		log.Info("Backup registry is not healthy", "Registry", c.registry, "Error", err.Error())
+	}
+	healthChecksLock.Lock()
+	healthChecks[key] = healthCheck{healthy: err == nil, time: time.Now()}
+	healthChecksLock.Unlock()
+	return err == nil
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDestinations(t *testing.T) {
+	cli := NewClient("quay.io", "org", "user", "pwd", 0)
+	cli.mirrors = []*Client{
+		{registry: "dr.corp.internal", organization: "backup", priority: 2},
+		{registry: "registry.corp.internal", organization: "backup", priority: -1},
+	}
+
+	expected := []string{"registry.corp.internal/backup/nginx:1.19", "quay.io/org/nginx:1.19", "dr.corp.internal/backup/nginx:1.19"}
+	if output := cli.Destinations("nginx:1.19"); !reflect.DeepEqual(output, expected) {
+		t.Errorf("Expected %v, got %v", expected, output)
+	}
+	if !cli.Belongs("dr.corp.internal/backup/nginx:1.19") {
+		t.Errorf("Expected the image of an additional registry to belong to the client")
+	}
+	if owner := cli.owner("dr.corp.internal/backup/nginx:1.19"); owner.registry != "dr.corp.internal" {
+		t.Errorf("Expected dr.corp.internal to own the image, got %s", owner.registry)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	testCases := []struct {
+		name      string
+		primary   string
+		secondary string
+		copies    []string
+		expected  string
+	}{
+		{
+			name:      "Primary healthy",
+			primary:   up.URL,
+			secondary: up.URL,
+			copies:    []string{"quay.io/org/nginx", "dr.corp.internal/backup/nginx"},
+			expected:  "quay.io/org/nginx",
+		},
+		{
+			name:      "Primary unhealthy",
+			primary:   down.URL,
+			secondary: up.URL,
+			copies:    []string{"quay.io/org/nginx", "dr.corp.internal/backup/nginx"},
+			expected:  "dr.corp.internal/backup/nginx",
+		},
+		{
+			name:      "All unhealthy",
+			primary:   down.URL,
+			secondary: down.URL,
+			copies:    []string{"quay.io/org/nginx", "dr.corp.internal/backup/nginx"},
+			expected:  "quay.io/org/nginx",
+		},
+		{
+			name:      "Single copy",
+			primary:   up.URL,
+			secondary: up.URL,
+			copies:    []string{"dr.corp.internal/backup/nginx"},
+			expected:  "dr.corp.internal/backup/nginx",
+		},
+		{
+			name:      "Copy to another registry",
+			primary:   up.URL,
+			secondary: up.URL,
+			copies:    []string{"docker.io/old/nginx"},
+			expected:  "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			healthChecks = map[string]healthCheck{}
+			cli := NewClient("quay.io", "org", "user", "pwd", 0)
+			cli.apiEndpoint = tc.primary
+			mirror := NewClient("dr.corp.internal", "backup", "dr-user", "pwd", 0)
+			mirror.apiEndpoint = tc.secondary
+			mirror.priority = 1
+			cli.mirrors = []*Client{mirror}
+
+			if output := cli.Target("nginx", tc.copies); output != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+const (
+	// Docker Hub credentials are looked up by the container runtimes under this key
+	dockerHubAuthKey = "https://index.docker.io/v1/"
+)
+
+// dockerConfig is the content of a kubernetes.io/dockerconfigjson Secret
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// DockerConfigJSON returns the docker config with the client's credentials for all the backup registries,
+// to be used as a pull Secret by the workloads using the backup images
+func (c *Client) DockerConfigJSON() ([]byte, error) {
+	cfg := &dockerConfig{Auths: map[string]dockerAuth{}}
+	for _, d := range c.current().destinations() {
+		key := d.aliases()[0]
+		if d.registry == "registry-1.docker.io" {
+			key = dockerHubAuthKey
+		}
+		cfg.Auths[key] = dockerAuth{
+			Username: d.username,
+			Password: d.password,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(d.username + ":" + d.password)),
+		}
+	}
+	return json.Marshal(cfg)
+}
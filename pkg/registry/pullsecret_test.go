@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestDockerConfigJSON(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cli      *Client
+		expected string
+	}{
+		{
+			name:     "Docker Hub",
+			cli:      NewClient("registry-1.docker.io", "org", "user", "pwd", 0),
+			expected: `{"auths":{"https://index.docker.io/v1/":{"username":"user","password":"pwd","auth":"dXNlcjpwd2Q="}}}`,
+		},
+		{
+			name:     "Quay",
+			cli:      NewClient("quay.io", "org", "user", "pwd", 0),
+			expected: `{"auths":{"quay.io":{"username":"user","password":"pwd","auth":"dXNlcjpwd2Q="}}}`,
+		},
+		{
+			name:     "Registry without aliases",
+			cli:      NewClient("registry.corp.internal", "org", "user", "pwd", 0),
+			expected: `{"auths":{"registry.corp.internal":{"username":"user","password":"pwd","auth":"dXNlcjpwd2Q="}}}`,
+		},
+		{
+			name: "Additional registries",
+			cli: &Client{
+				registry: "quay.io", organization: "org", username: "user", password: "pwd",
+				mirrors: []*Client{{registry: "registry.corp.internal", organization: "backup", username: "dr", password: "pwd", priority: 1}},
+			},
+			expected: `{"auths":{"quay.io":{"username":"user","password":"pwd","auth":"dXNlcjpwd2Q="},"registry.corp.internal":{"username":"dr","password":"pwd","auth":"ZHI6cHdk"}}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := tc.cli.DockerConfigJSON()
+			if err != nil {
+				t.Fatalf("Got not expected error: %v", err)
+			}
+			if string(output) != tc.expected {
+				t.Errorf("Output didn't match. Expected: %s, got: %s", tc.expected, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package scan
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/controller/utils"
+	"image-clone-controller/pkg/controller/workload"
+	"image-clone-controller/pkg/registry"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// inspect returns the digest of the given backup image, the registry client's Inspect unless tested
+var inspect = func(regClient *registry.Client, fullName string) (*registry.ImageInfo, error) {
+	return regClient.Inspect(fullName)
+}
+
+// Report is the inventory of the images of the workloads of a cluster
+type Report struct {
+	Images []Image `json:"images"`
+	// Total is the number of the containers of the workloads of the namespaces the controller watches
+	Total int `json:"total"`
+	// OnBackup is the number of these containers using a backup image
+	OnBackup int `json:"onBackup"`
+	// BackedUp is the number of these containers whose image is backed up, used or not
+	BackedUp int `json:"backedUp"`
+	// Coverage is the percentage of these containers using a backup image
+	Coverage float64 `json:"coverage"`
+}
+
+// Image describes the image of a container of a workload
+type Image struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	// Excluded is true if the controller doesn't watch the namespace: blacklisted, not whitelisted or not matching the label selector
+	Excluded bool `json:"excluded"`
+	// Belongs is true if the image is a backup image
+	Belongs bool `json:"belongs"`
+	// BackedUp is true if a backup of the image exists
+	BackedUp bool   `json:"backedUp"`
+	Digest   string `json:"digest,omitempty"`
+}
+
+// Scan lists the images of all the supported workloads with their backup state.
+// The images without an ImageBackup, e.g. copied by the backup subcommand, are looked up in the backup registry.
+func Scan(c client.Reader, regClient *registry.Client, cfg *config.Config) (*Report, error) {
+	workloads, err := workload.ListAll(c, "")
+	if err != nil {
+		return nil, err
+	}
+	watched, err := watchedNamespaces(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// namespaces having their own destination, with its credentials to look up the backup images
+	dests := &v1alpha1.BackupDestinationList{}
+	if err := c.List(context.Background(), dests); err != nil {
+		return nil, err
+	}
+	nsClients := map[string]*registry.Client{}
+	for _, d := range dests.Items {
+		if d.Name != v1alpha1.DefaultBackupDestinationName {
+			continue
+		}
+		nsClient, err := workload.NamespaceRegistryClient(c, c, d.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if nsClient != nil {
+			nsClients[d.Namespace] = nsClient
+		}
+	}
+
+	backups := &v1alpha1.ImageBackupList{}
+	if err := c.List(context.Background(), backups); err != nil {
+		return nil, err
+	}
+	byName, byReplica := map[string]*v1alpha1.ImageBackup{}, map[string]*v1alpha1.ImageBackup{}
+	for i := range backups.Items {
+		b := &backups.Items[i]
+		if !b.BackedUp() {
+			continue
+		}
+		byName[b.Name] = b
+		for _, r := range b.ReplicaImages() {
+			byReplica[r] = b
+		}
+	}
+
+	// digests of the backup images found in the backup registries, empty if not found
+	found := map[string]string{}
+	lookup := func(cli *registry.Client, backupImage string) string {
+		if digest, done := found[backupImage]; done {
+			return digest
+		}
+		if info, err := inspect(cli, backupImage); err == nil {
+			found[backupImage] = info.Digest
+		} else {
+			found[backupImage] = ""
+		}
+		return found[backupImage]
+	}
+
+	report := &Report{Images: []Image{}}
+	for _, w := range workloads {
+		ns := w.Object.GetNamespace()
+		nsClient, destNamespace := regClient, ""
+		if cli, exists := nsClients[ns]; exists {
+			nsClient, destNamespace = cli, ns
+		}
+		excluded := !watched(ns)
+
+		for _, container := range w.Template.Spec.Containers {
+			i := Image{
+				Kind:      w.Kind,
+				Namespace: ns,
+				Name:      w.Object.GetName(),
+				Container: container.Name,
+				Image:     container.Image,
+				Excluded:  excluded,
+				Belongs:   nsClient.Belongs(container.Image),
+			}
+			b := byName[v1alpha1.NamespaceImageBackupName(destNamespace, container.Image)]
+			backupImage := nsClient.Destination(container.Image)
+			if i.Belongs {
+				b, backupImage = byReplica[container.Image], container.Image
+			}
+			if b != nil {
+				i.BackedUp, i.Digest = true, b.Status.Digest
+			} else if digest := lookup(nsClient, backupImage); digest != "" {
+				i.BackedUp, i.Digest = true, digest
+			}
+			report.Images = append(report.Images, i)
+
+			if excluded {
+				continue
+			}
+			report.Total++
+			if i.Belongs {
+				report.OnBackup++
+			}
+			if i.Belongs || i.BackedUp {
+				report.BackedUp++
+			}
+		}
+	}
+	if report.Total > 0 {
+		report.Coverage = 100 * float64(report.OnBackup) / float64(report.Total)
+	}
+	return report, nil
+}
+
+// watchedNamespaces returns a function telling whether the controller watches the given namespace
+// according to the namespace blacklist, whitelist and label selector
+func watchedNamespaces(c client.Reader, cfg *config.Config) (func(string) bool, error) {
+	lists := utils.NewBlacklistNamespacePredicate(cfg.NamespaceBlacklist(), cfg.NamespaceWhitelist())
+	selector := cfg.NamespaceLabelSelector()
+	selected := map[string]bool{}
+	if !selector.Empty() {
+		namespaces := &corev1.NamespaceList{}
+		if err := c.List(context.Background(), namespaces); err != nil {
+			return nil, err
+		}
+		for _, ns := range namespaces.Items {
+			selected[ns.Name] = selector.Matches(labels.Set(ns.Labels))
+		}
+	}
+	return func(namespace string) bool {
+		return lists.Watched(namespace) && (selector.Empty() || selected[namespace])
+	}, nil
+}
+
+// WriteTable writes the report as a table followed by the coverage
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAMESPACE\tNAME\tCONTAINER\tIMAGE\tEXCLUDED\tBELONGS\tBACKED UP\tDIGEST")
+	for _, i := range r.Images {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\t%t\t%t\t%s\n", i.Kind, i.Namespace, i.Name, i.Container, i.Image, i.Excluded, i.Belongs, i.BackedUp, i.Digest)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+	return r.WriteCoverage(w)
+}
+
+// WriteCoverage writes the coverage figures on one line
+func (r *Report) WriteCoverage(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "Coverage: %.1f%% (%d of %d images on backup images, %d backed up)\n", r.Coverage, r.OnBackup, r.Total, r.BackedUp)
+	return err
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes the images of the report as CSV, one record per container.
+// The coverage figures are not part of it for all the records to have the same fields, see WriteCoverage.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{{"kind", "namespace", "name", "container", "image", "excluded", "belongs", "backedUp", "digest"}}
+	for _, i := range r.Images {
+		records = append(records, []string{i.Kind, i.Namespace, i.Name, i.Container, i.Image,
+			strconv.FormatBool(i.Excluded), strconv.FormatBool(i.Belongs), strconv.FormatBool(i.BackedUp), i.Digest})
+	}
+	return cw.WriteAll(records)
+}
@@ -0,0 +1,224 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"image-clone-controller/pkg/apis/imageclone/v1alpha1"
+	"image-clone-controller/pkg/config"
+	"image-clone-controller/pkg/registry"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestScan(t *testing.T) {
+	syncTime := metav1.Now()
+	backup := func(source, dest, digest string) *v1alpha1.ImageBackup {
+		return &v1alpha1.ImageBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.ImageBackupName(source)},
+			Spec:       v1alpha1.ImageBackupSpec{Source: source},
+			Status:     v1alpha1.ImageBackupStatus{Source: source, Destination: dest, Digest: digest, LastSyncTime: &syncTime},
+		}
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "a", Image: "quay.io/org/nginx"},
+			{Name: "b", Image: "redis"},
+			{Name: "c", Image: "busybox"},
+		}}}},
+	}
+	daemonset := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "proxy"},
+		Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "a", Image: "kube-proxy"},
+		}}}},
+	}
+
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		t.Fatalf("Failed to build the scheme: %v", err)
+	}
+	if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+		t.Fatalf("Failed to build the scheme: %v", err)
+	}
+	cli := fake.NewFakeClientWithScheme(s, deployment, daemonset,
+		backup("nginx", "quay.io/org/nginx", "sha256:a"), backup("redis", "quay.io/org/redis", "sha256:b"))
+	cfg := &config.Config{MandatoryNamespaceBlacklist: []string{"kube-system"}}
+	// nothing is backed up out of the ImageBackups
+	inspect = func(regClient *registry.Client, fullName string) (*registry.ImageInfo, error) {
+		return nil, errors.New("manifest unknown")
+	}
+
+	report, err := Scan(cli, registry.NewClient("quay.io", "org", "", "", 0), cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []Image{
+		{Kind: "Deployment", Namespace: "ns", Name: "app", Container: "a", Image: "quay.io/org/nginx", Belongs: true, BackedUp: true, Digest: "sha256:a"},
+		{Kind: "Deployment", Namespace: "ns", Name: "app", Container: "b", Image: "redis", BackedUp: true, Digest: "sha256:b"},
+		{Kind: "Deployment", Namespace: "ns", Name: "app", Container: "c", Image: "busybox"},
+		{Kind: "DaemonSet", Namespace: "kube-system", Name: "proxy", Container: "a", Image: "kube-proxy", Excluded: true},
+	}
+	if !reflect.DeepEqual(report.Images, expected) {
+		t.Errorf("Expected images %+v, got %+v", expected, report.Images)
+	}
+	if report.Total != 3 || report.OnBackup != 1 || report.BackedUp != 2 {
+		t.Errorf("Expected 1 of 3 images on backup images and 2 backed up, got %d of %d and %d", report.OnBackup, report.Total, report.BackedUp)
+	}
+
+	output := &bytes.Buffer{}
+	if err := report.WriteCSV(output); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	records, err := csv.NewReader(output).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected records having the same number of fields, got: %v", err)
+	}
+	if len(records) != 5 || strings.Join(records[1], ",") != "Deployment,ns,app,a,quay.io/org/nginx,false,true,true,sha256:a" {
+		t.Errorf("Unexpected CSV records: %v", records)
+	}
+	output.Reset()
+	if err := report.WriteTable(output); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(output.String(), "Coverage: 33.3% (1 of 3 images on backup images, 2 backed up)") {
+		t.Errorf("Expected the coverage in the table output:\n%s", output.String())
+	}
+}
+
+func TestScanNamespaceSelection(t *testing.T) {
+	namespace := func(name, team string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"team": team}}}
+	}
+	deployment := func(ns, image string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "app"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "a", Image: image},
+			}}}},
+		}
+	}
+
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		t.Fatalf("Failed to build the scheme: %v", err)
+	}
+	if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+		t.Fatalf("Failed to build the scheme: %v", err)
+	}
+	cli := fake.NewFakeClientWithScheme(s,
+		namespace("ns", "a"), namespace("other", "b"), namespace("dev", "a"),
+		deployment("ns", "busybox"), deployment("other", "nginx"), deployment("dev", "redis"))
+	cfg := &config.Config{WhitelistedNamespaces: []string{"ns", "other"}, NamespaceSelector: "team=a"}
+	// busybox was copied by the backup subcommand: no ImageBackup
+	inspect = func(regClient *registry.Client, fullName string) (*registry.ImageInfo, error) {
+		if fullName == "quay.io/org/busybox" {
+			return &registry.ImageInfo{Name: fullName, Digest: "sha256:c"}, nil
+		}
+		return nil, errors.New("manifest unknown")
+	}
+
+	report, err := Scan(cli, registry.NewClient("quay.io", "org", "", "", 0), cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	excluded := map[string]bool{}
+	for _, i := range report.Images {
+		excluded[i.Namespace] = i.Excluded
+		if i.Image == "busybox" && (!i.BackedUp || i.Digest != "sha256:c") {
+			t.Errorf("Expected the image copied by the backup subcommand to be backed up, got %+v", i)
+		}
+	}
+	expected := map[string]bool{"ns": false, "other": true, "dev": true}
+	if !reflect.DeepEqual(excluded, expected) {
+		t.Errorf("Expected excluded namespaces %v, got %v", expected, excluded)
+	}
+	if report.Total != 1 || report.OnBackup != 0 || report.BackedUp != 1 {
+		t.Errorf("Expected 0 of 1 image on backup images and 1 backed up, got %d of %d and %d", report.OnBackup, report.Total, report.BackedUp)
+	}
+}
+
+func TestScanBackupDestinationCredentials(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "tenant", Name: "app"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "a", Image: "nginx"},
+		}}}},
+	}
+	dest := &v1alpha1.BackupDestination{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "tenant", Name: v1alpha1.DefaultBackupDestinationName},
+		Spec:       v1alpha1.BackupDestinationSpec{Registry: "registry.tenant.io", Organization: "tenant", CredentialsSecret: "registry"},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "tenant", Name: "registry"},
+		Data: map[string][]byte{
+			v1alpha1.BackupDestinationUsernameKey: []byte("robot"),
+			v1alpha1.BackupDestinationPasswordKey: []byte("secret"),
+		},
+	}
+	// the backup image is only found with the credentials of the tenant
+	inspect = func(regClient *registry.Client, fullName string) (*registry.ImageInfo, error) {
+		auths, _ := regClient.DockerConfigJSON()
+		if fullName == "registry.tenant.io/tenant/nginx" && strings.Contains(string(auths), `"username":"robot"`) {
+			return &registry.ImageInfo{Name: fullName, Digest: "sha256:d"}, nil
+		}
+		return nil, errors.New("unauthorized")
+	}
+
+	testCases := []struct {
+		name           string
+		objects        []runtime.Object
+		expectedDigest string
+		expectedError  bool
+	}{
+		{
+			name:           "Credentials",
+			objects:        []runtime.Object{deployment, dest, secret},
+			expectedDigest: "sha256:d",
+		},
+		{
+			name:          "Missing credentials",
+			objects:       []runtime.Object{deployment, dest},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			if err := scheme.AddToScheme(s); err != nil {
+				t.Fatalf("Failed to build the scheme: %v", err)
+			}
+			if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+				t.Fatalf("Failed to build the scheme: %v", err)
+			}
+			cli := fake.NewFakeClientWithScheme(s, tc.objects...)
+
+			report, err := Scan(cli, registry.NewClient("quay.io", "org", "", "", 0), &config.Config{})
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", report)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(report.Images) != 1 || !report.Images[0].BackedUp || report.Images[0].Digest != tc.expectedDigest {
+				t.Errorf("Expected the image backed up with digest %s, got %+v", tc.expectedDigest, report.Images)
+			}
+		})
+	}
+}
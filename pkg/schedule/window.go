@@ -0,0 +1,206 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// the next window opening is searched within this number of years
+	maxSearchYears = 5
+)
+
+// Window is a recurring period of time: it opens at the times matching a cron expression and stays open for a duration.
+// E.g. "0 22 * * 1-5 4h" opens at 22:00 from Monday to Friday for 4 hours.
+type Window struct {
+	expr     string
+	start    *cron
+	duration time.Duration
+}
+
+// ParseWindow parses the 5 fields of a cron expression (minute, hour, day of month, month, day of week)
+// followed by the duration of the window
+func ParseWindow(s string) (*Window, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("window %q: expected 5 cron fields and a duration", s)
+	}
+	start, err := parseCron(fields[:5])
+	if err != nil {
+		return nil, fmt.Errorf("window %q: %v", s, err)
+	}
+	duration, err := time.ParseDuration(fields[5])
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("window %q: invalid duration %q", s, fields[5])
+	}
+	return &Window{expr: s, start: start, duration: duration}, nil
+}
+
+// String returns the window expression
+func (w *Window) String() string {
+	return w.expr
+}
+
+// Open returns true if the window is open at the given time
+func (w *Window) Open(t time.Time) bool {
+	// the window is open if it started after t-duration, at t at the latest
+	start, found := w.start.next(t.Add(-w.duration).Add(time.Nanosecond))
+	return found && !start.After(t)
+}
+
+// NextOpening returns the first time the window opens at or after the given time, false if it never opens
+func (w *Window) NextOpening(t time.Time) (time.Time, bool) {
+	return w.start.next(t)
+}
+
+// Windows is a set of windows, an empty set is always open
+type Windows []*Window
+
+// ParseWindows parses the given windows
+func ParseWindows(exprs []string) (Windows, error) {
+	windows := Windows{}
+	for _, e := range exprs {
+		if strings.TrimSpace(e) == "" {
+			continue
+		}
+		w, err := ParseWindow(e)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// Open returns true if any of the windows is open at the given time or if there is no window at all
+func (ws Windows) Open(t time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	for _, w := range ws {
+		if w.Open(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpening returns the first time any of the windows opens at or after the given time, false if none ever opens
+func (ws Windows) NextOpening(t time.Time) (time.Time, bool) {
+	next, found := time.Time{}, false
+	for _, w := range ws {
+		if o, ok := w.NextOpening(t); ok && (!found || o.Before(next)) {
+			next, found = o, true
+		}
+	}
+	return next, found
+}
+
+// cron is a parsed cron expression
+type cron struct {
+	minutes, hours, days, months, weekdays []bool
+	// cron semantic: if both the day of month and the day of week are restricted, any of them has to match
+	daysRestricted, weekdaysRestricted bool
+}
+
+// parseCron parses the 5 fields of a cron expression
+func parseCron(fields []string) (*cron, error) {
+	c := &cron{}
+	var err error
+	if c.minutes, _, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if c.hours, _, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if c.days, c.daysRestricted, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if c.months, _, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if c.weekdays, c.weekdaysRestricted, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	// 7 is Sunday as well
+	c.weekdays[0] = c.weekdays[0] || c.weekdays[7]
+	return c, nil
+}
+
+// parseField parses a cron field: *, values, ranges and steps separated by commas (e.g. 1-5,*/15).
+// Returns the matching values and whether the field is restricted (not *).
+func parseField(field string, min, max int) ([]bool, bool, error) {
+	values := make([]bool, max+1)
+	restricted := field != "*"
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, false, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], s
+		}
+
+		from, to := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, false, fmt.Errorf("invalid value in %q", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, false, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if step > 1 {
+				// e.g. 5/15: from 5 to the max
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, false, fmt.Errorf("%q out of range [%d-%d]", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, restricted, nil
+}
+
+// next returns the first time matching the expression at or after the given time, false if not found within a few years
+func (c *cron) next(t time.Time) (time.Time, bool) {
+	// starting from the next whole minute
+	if rounded := t.Truncate(time.Minute); !rounded.Equal(t) {
+		t = rounded.Add(time.Minute)
+	}
+	limit := t.AddDate(maxSearchYears, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case !c.months[m]:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+		case !c.hours[t.Hour()]:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// dayMatches returns true if the day of the given time matches the expression
+func (c *cron) dayMatches(t time.Time) bool {
+	day, weekday := c.days[t.Day()], c.weekdays[t.Weekday()]
+	if c.daysRestricted && c.weekdaysRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
@@ -0,0 +1,152 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	testCases := []struct {
+		name          string
+		input         string
+		expectedError bool
+	}{
+		{
+			name:  "Every night",
+			input: "0 2 * * * 3h",
+		},
+		{
+			name:  "Lists ranges and steps",
+			input: "*/15 8-18 1,15 1-12/2 1-5 10m",
+		},
+		{
+			name:          "Missing duration",
+			input:         "0 2 * * *",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid duration",
+			input:         "0 2 * * * -1h",
+			expectedError: true,
+		},
+		{
+			name:          "Out of range",
+			input:         "0 24 * * * 1h",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid step",
+			input:         "*/0 * * * * 1h",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid range",
+			input:         "0 5-2 * * * 1h",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseWindow(tc.input)
+			if tc.expectedError != (err != nil) {
+				t.Errorf("Expected error: %v, got: %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestWindowOpen(t *testing.T) {
+	testCases := []struct {
+		name             string
+		windows          []string
+		now              string
+		expectedOpen     bool
+		expectedOpening  string
+		expectedNoWindow bool
+	}{
+		{
+			name:         "No window",
+			now:          "2020-03-04T12:00:00Z",
+			expectedOpen: true,
+		},
+		{
+			name:            "Before the window",
+			windows:         []string{"0 2 * * * 3h"},
+			now:             "2020-03-04T01:30:00Z",
+			expectedOpening: "2020-03-04T02:00:00Z",
+		},
+		{
+			name:         "At the opening",
+			windows:      []string{"0 2 * * * 3h"},
+			now:          "2020-03-04T02:00:00Z",
+			expectedOpen: true,
+		},
+		{
+			name:         "Open since the previous day",
+			windows:      []string{"0 22 * * * 4h"},
+			now:          "2020-03-04T01:59:59Z",
+			expectedOpen: true,
+		},
+		{
+			name:            "At the closing",
+			windows:         []string{"0 2 * * * 3h"},
+			now:             "2020-03-04T05:00:00Z",
+			expectedOpening: "2020-03-05T02:00:00Z",
+		},
+		{
+			name:            "Weekdays only",
+			windows:         []string{"0 22 * * 1-5 2h"},
+			now:             "2020-03-07T12:00:00Z", // Saturday
+			expectedOpening: "2020-03-09T22:00:00Z", // Monday
+		},
+		{
+			name:            "Sunday as 7",
+			windows:         []string{"30 10 * * 7 1h"},
+			now:             "2020-03-04T12:00:00Z",
+			expectedOpening: "2020-03-08T10:30:00Z",
+		},
+		{
+			name:            "Earliest of several windows",
+			windows:         []string{"0 2 * * * 1h", "0 13 * * * 1h"},
+			now:             "2020-03-04T12:00:00Z",
+			expectedOpening: "2020-03-04T13:00:00Z",
+		},
+		{
+			name:            "Day of month or day of week",
+			windows:         []string{"0 0 1 * 0 1h"},
+			now:             "2020-03-02T12:00:00Z",
+			expectedOpening: "2020-03-08T00:00:00Z",
+		},
+		{
+			name:             "Never opens",
+			windows:          []string{"0 0 31 2 * 1h"},
+			now:              "2020-03-04T12:00:00Z",
+			expectedNoWindow: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			windows, err := ParseWindows(tc.windows)
+			if err != nil {
+				t.Fatalf("Got not expected error: %v", err)
+			}
+			now, _ := time.Parse(time.RFC3339, tc.now)
+
+			if open := windows.Open(now); open != tc.expectedOpen {
+				t.Errorf("Expected open: %v, got: %v", tc.expectedOpen, open)
+			}
+			if tc.expectedOpen {
+				return
+			}
+			opening, found := windows.NextOpening(now)
+			if found == tc.expectedNoWindow {
+				t.Fatalf("Expected no opening: %v, got: %v", tc.expectedNoWindow, opening)
+			}
+			if found && opening.Format(time.RFC3339) != tc.expectedOpening {
+				t.Errorf("Expected opening at %s, got %s", tc.expectedOpening, opening.Format(time.RFC3339))
+			}
+		})
+	}
+}